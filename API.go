@@ -89,8 +89,9 @@ type PositionCalculator interface {
 // A superinterface for any object that renders a finished image layout to a ready-to-write output image.
 type CollageRenderer interface {
 	CollageCreatorComponent
-	// Takes 'imageLayout' after it has been through a 'PositionCalculator' and produces an object
-	// ready to write to output file(s), or error on failure.
+	// Takes 'imageLayout' after it has been through a 'PositionCalculator' and 'SnapToPixels'
+	// and produces an object ready to write to output file(s), or error on failure. Renderers
+	// may assume that every position and dimension in 'imageLayout' is a whole number of pixels.
 	CreateCollageImage(imageLayout ImageLayout) (oi OutputImage, err error)
 }
 
@@ -117,6 +118,7 @@ func Parameters_init() Parameters {
 	params.data.maxCanvasSize = NewDims(0, 0)
 	params.data.minCanvasSize = NewDims(0, 0)
 	params.data.padding = EmptyGeometry()
+	params.data.imageCache = NewImageCache(imageCache_DefaultCapacity)
 	return params
 }
 
@@ -194,6 +196,16 @@ func (p *Parameters) SetPadding(padding Geometry) {
 	p.data.padding = padding
 }
 
+// Gets the cache of decoded images used by input images that are not preloaded.
+func (p Parameters) ImageCache() *ImageCache {
+	return p.data.imageCache
+}
+
+// Sets the cache of decoded images used by input images that are not preloaded.
+func (p *Parameters) SetImageCache(imageCache *ImageCache) {
+	p.data.imageCache = imageCache
+}
+
 // Gets the ProgressMonitor used to report status.
 func (p Parameters) ProgressMonitor() ProgressMonitor {
 	return p.data.progressMonitor
@@ -364,12 +376,17 @@ type parameters_data struct {
 	dimensionInitializer DimensionInitializer
 	positionCalculator   PositionCalculator
 	collageRenderer      CollageRenderer
+	imageCache           *ImageCache
 	others               CustomParameters
 }
 
 // Runs the complete collage-creation process from reading input files to producing
-// the output file; returns 0 if successful and nonzero if not.
+// the output file; returns 0 if successful and nonzero if not. If unreadable input
+// images are being skipped, those that could not be read are summarized at the end.
 func CreateCollage(parameters *Parameters) int {
+	defer ReportReadFailures(parameters)
+	defer closeArchiveIndexes()
+	defer releaseGifFrames()
 	imageLayout, err := parameters.InputImageReader().ReadInputImages(parameters)
 	if err != nil {
 		parameters.ProgressMonitor().ReportRuntimeError("Error reading input images", err)
@@ -388,6 +405,7 @@ func CreateCollage(parameters *Parameters) int {
 		parameters.ProgressMonitor().ReportPositioningFailure()
 		return 1
 	}
+	laidOut = SnapToPixels(laidOut)
 	collageImage, err := parameters.CollageRenderer().CreateCollageImage(laidOut)
 	if err != nil {
 		parameters.ProgressMonitor().ReportRuntimeError("Error rendering collage", err)
@@ -0,0 +1,413 @@
+// This file contains auxiliary methods that read input images directly from
+// zip and tar (optionally gzip-compressed) archives. An image inside an archive
+// is named by the archive's pathname and the entry's name within it, separated
+// by '!' (e.g. 'photos.zip!2021/beach.jpg').
+package CollageCreator
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	Archive_ExtractDir string = "Archive_ExtractDir"
+)
+
+// The usage of the flags by which the renderers that link to input images are given a
+// directory to extract them into.
+const archive_ExtractDirUsage string = "Write input images within archives, frames of animated GIFs, and images held in memory into this directory so that the output can refer to them"
+
+// Separates an archive's pathname from the name of an entry within it.
+const archiveEntrySeparator string = "!"
+
+// The extensions of the entries taken from an archive when no pattern is given.
+var archiveImageExtensions = map[string]bool{
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".bmp": true, ".tif": true, ".tiff": true, ".webp": true,
+}
+
+// Determines whether a pathname names a zip or tar archive, by its extension.
+func IsArchivePath(fileName string) bool {
+	lower := strings.ToLower(fileName)
+	for _, ext := range []string{".zip", ".tar", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(lower, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// Splits a name of the form 'archive!entry' into the archive's pathname and the entry's name.
+// Returns false if the name does not refer to an entry within an archive.
+func SplitArchiveName(name string) (archive, entry string, inArchive bool) {
+	for i := strings.Index(name, archiveEntrySeparator); i >= 0; {
+		if IsArchivePath(name[:i]) {
+			return name[:i], name[i+len(archiveEntrySeparator):], true
+		}
+		next := strings.Index(name[i+1:], archiveEntrySeparator)
+		if next < 0 {
+			break
+		}
+		i += 1 + next
+	}
+	return name, "", false
+}
+
+// The regular files of an archive, read once per run. The entries of a zip archive are
+// read from it as they are needed. Those of a tar archive are read from where the index
+// found them; a compressed tar archive, which cannot be read out of order, is first
+// decompressed into a temporary file.
+type archiveIndex struct {
+	modTime time.Time
+	size    int64
+	// The names of the regular files, in the order in which they appear in the archive.
+	names     []string
+	zipReader *zip.ReadCloser
+	zipFiles  map[string]*zip.File
+	tarFile   *os.File
+	// Whether 'tarFile' is a temporary file to be removed when the index is closed.
+	tarSpooled bool
+	tarEntries map[string]archiveIndex_tarEntry
+}
+
+// Where the contents of an entry of a tar archive lie in the uncompressed archive. The
+// contents of a sparse entry, which are not stored contiguously, are held in memory instead.
+type archiveIndex_tarEntry struct {
+	offset   int64
+	size     int64
+	contents []byte
+}
+
+// The archives indexed so far in this run, by pathname.
+var archiveIndexes = struct {
+	sync.Mutex
+	indexes map[string]*archiveIndex
+}{indexes: map[string]*archiveIndex{}}
+
+// Gets the index of an archive, reading it if it has not been read, or has changed, since
+// it was last indexed.
+func indexArchive(archive string) (*archiveIndex, error) {
+	stat, err := os.Stat(archive)
+	if err != nil {
+		return nil, err
+	}
+	archiveIndexes.Lock()
+	defer archiveIndexes.Unlock()
+	if index, in := archiveIndexes.indexes[archive]; in {
+		if index.modTime.Equal(stat.ModTime()) && index.size == stat.Size() {
+			return index, nil
+		}
+		index.close()
+		delete(archiveIndexes.indexes, archive)
+	}
+	index := &archiveIndex{modTime: stat.ModTime(), size: stat.Size()}
+	if strings.HasSuffix(strings.ToLower(archive), ".zip") {
+		err = index.readZip(archive)
+	} else {
+		err = index.readTar(archive)
+	}
+	if err != nil {
+		index.close()
+		return nil, err
+	}
+	archiveIndexes.indexes[archive] = index
+	return index, nil
+}
+
+// Closes every archive indexed so far, so that the next run reads them afresh.
+func closeArchiveIndexes() {
+	archiveIndexes.Lock()
+	defer archiveIndexes.Unlock()
+	for archive, index := range archiveIndexes.indexes {
+		index.close()
+		delete(archiveIndexes.indexes, archive)
+	}
+}
+
+func (ai *archiveIndex) readZip(archive string) (err error) {
+	if ai.zipReader, err = zip.OpenReader(archive); err != nil {
+		return
+	}
+	ai.zipFiles = map[string]*zip.File{}
+	for _, f := range ai.zipReader.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		if _, in := ai.zipFiles[f.Name]; !in {
+			ai.names = append(ai.names, f.Name)
+			ai.zipFiles[f.Name] = f
+		}
+	}
+	return
+}
+
+func (ai *archiveIndex) readTar(archive string) (err error) {
+	if ai.tarFile, err = os.Open(archive); err != nil {
+		return
+	}
+	lower := strings.ToLower(archive)
+	if strings.HasSuffix(lower, ".gz") || strings.HasSuffix(lower, ".tgz") {
+		if err = ai.spoolTar(); err != nil {
+			return
+		}
+	}
+	ai.tarEntries = map[string]archiveIndex_tarEntry{}
+	tr := tar.NewReader(ai.tarFile)
+	for {
+		header, errN := tr.Next()
+		if errN == io.EOF {
+			return nil
+		} else if errN != nil {
+			return errN
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := strings.TrimPrefix(header.Name, "./")
+		if _, in := ai.tarEntries[name]; in {
+			continue
+		}
+		// Having read the header, the reader stands at the start of the entry's contents.
+		offset, errS := ai.tarFile.Seek(0, io.SeekCurrent)
+		if errS != nil {
+			return errS
+		}
+		entry := archiveIndex_tarEntry{offset: offset, size: header.Size}
+		if _, sparse := header.PAXRecords["GNU.sparse.major"]; sparse {
+			if entry.contents, err = io.ReadAll(tr); err != nil {
+				return
+			}
+		}
+		ai.names = append(ai.names, name)
+		ai.tarEntries[name] = entry
+	}
+}
+
+// Decompresses the gzip-compressed tar archive open in 'tarFile' into a temporary file,
+// which replaces it.
+func (ai *archiveIndex) spoolTar() error {
+	gz, err := gzip.NewReader(ai.tarFile)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	spooled, err := os.CreateTemp("", "collage-archive-*.tar")
+	if err != nil {
+		return err
+	}
+	compressed := ai.tarFile
+	ai.tarFile, ai.tarSpooled = spooled, true
+	_, err = io.Copy(spooled, gz)
+	compressed.Close()
+	if err != nil {
+		return err
+	}
+	_, err = spooled.Seek(0, io.SeekStart)
+	return err
+}
+
+// Reads the contents of the entry of the given name. Returns false if there is no such entry.
+func (ai *archiveIndex) read(entry string) (contents []byte, found bool, err error) {
+	if ai.zipFiles != nil {
+		f, in := ai.zipFiles[entry]
+		if !in {
+			return nil, false, nil
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, true, err
+		}
+		defer rc.Close()
+		contents, err = io.ReadAll(rc)
+		return contents, true, err
+	}
+	tarEntry, found := ai.tarEntries[entry]
+	if !found || tarEntry.contents != nil {
+		return tarEntry.contents, found, nil
+	}
+	contents = make([]byte, tarEntry.size)
+	if _, err = ai.tarFile.ReadAt(contents, tarEntry.offset); err != nil {
+		return nil, true, err
+	}
+	return contents, true, nil
+}
+
+func (ai *archiveIndex) close() {
+	if ai.zipReader != nil {
+		ai.zipReader.Close()
+	}
+	if ai.tarFile != nil {
+		ai.tarFile.Close()
+		if ai.tarSpooled {
+			os.Remove(ai.tarFile.Name())
+		}
+	}
+}
+
+// Lists the image entries of an archive, as names of the form 'archive!entry'. If 'pattern'
+// is not empty, only the entries whose names, or the last elements thereof, match it are listed;
+// otherwise, those with the extension of an image file are.
+func ListArchive(archive, pattern string) (names []string, err error) {
+	index, err := indexArchive(archive)
+	if err != nil {
+		return
+	}
+	for _, name := range index.names {
+		matched := archiveImageExtensions[strings.ToLower(path.Ext(name))]
+		if pattern != "" {
+			matchedFull, _ := path.Match(pattern, name)
+			matchedBase, _ := path.Match(pattern, path.Base(name))
+			matched = matchedFull || matchedBase
+		}
+		if matched {
+			names = append(names, archive+archiveEntrySeparator+name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool { return NaturalLess(names[i], names[j]) })
+	return
+}
+
+// Expands any input files that are archives, or patterns within archives (e.g. 'photos.zip!*.jpg'),
+// into the image entries they contain. Other input files are passed through unchanged.
+func expandArchives(files []string) (rv []string, err error) {
+	for _, file := range files {
+		archive, entry, inArchive := SplitArchiveName(file)
+		if !inArchive && !IsArchivePath(file) {
+			rv = append(rv, file)
+			continue
+		}
+		if inArchive && !strings.ContainsAny(entry, "*?[") {
+			rv = append(rv, file)
+			continue
+		}
+		var names []string
+		if names, err = ListArchive(archive, entry); err != nil {
+			return
+		}
+		if len(names) == 0 {
+			err = errors.New("no images found in archive: " + file)
+			return
+		}
+		rv = append(rv, names...)
+	}
+	return
+}
+
+// Reads the contents of an entry within an archive, named in the form 'archive!entry'.
+func readArchiveEntry(name string) (contents []byte, err error) {
+	archive, entry, inArchive := SplitArchiveName(name)
+	if !inArchive {
+		return nil, errors.New("not a file within an archive: " + name)
+	}
+	index, err := indexArchive(archive)
+	if err != nil {
+		return
+	}
+	contents, found, err := index.read(entry)
+	if err == nil && !found {
+		err = errors.New("no such entry in archive: " + name)
+	}
+	return
+}
+
+// An image file opened for reading, whether on disk or within an archive.
+type imageSource interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+}
+
+// Opens an image file, which may be within an archive, for reading. The returned function
+// must be called to close it.
+func openImageSource(fileName string) (source imageSource, closer func(), err error) {
+	if _, _, inArchive := SplitArchiveName(fileName); inArchive {
+		var contents []byte
+		if contents, err = readArchiveEntry(fileName); err != nil {
+			return
+		}
+		return bytes.NewReader(contents), func() {}, nil
+	}
+	fp, err := os.Open(fileName)
+	if err != nil {
+		return
+	}
+	return fp, func() { fp.Close() }, nil
+}
+
+// Gets a pathname by which an external program can read an input image. An image within an
+// archive, a frame of an animated GIF, or an image held in memory is written into 'extractDir',
+// if that is given; otherwise, an error is returned.
+func linkableImagePath(info ImageInfo, extractDir string) (string, error) {
+	fileName := info.FileName()
+	if encoded, ok := UnwrapImageInfo(info).(EncodedImageInfo); ok {
+		if extractDir == "" {
+			return "", errors.New("cannot link to an image held in memory (use -extract-archives or -svg-extract-archives): " + fileName)
+		}
+		extracted, err := writeEncodedImage(encoded, extractDir)
+		if err != nil {
+			return "", err
+		}
+		return filepath.Abs(extracted)
+	}
+	if _, _, isFrame := SplitFrameName(fileName); isFrame {
+		if extractDir == "" {
+			return "", errors.New("cannot link to a frame of an animated GIF (use -extract-archives or -svg-extract-archives): " + fileName)
+		}
+		extracted, err := ExtractGifFrame(fileName, extractDir)
+		if err != nil {
+			return "", err
+		}
+		return filepath.Abs(extracted)
+	}
+	if _, _, inArchive := SplitArchiveName(fileName); !inArchive {
+		if abs, err := filepath.Abs(fileName); err == nil {
+			return abs, nil
+		}
+		return fileName, nil
+	}
+	if extractDir == "" {
+		return "", errors.New("cannot link to an image within an archive (use -extract-archives or -svg-extract-archives): " + fileName)
+	}
+	extracted, err := ExtractArchiveEntry(fileName, extractDir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Abs(extracted)
+}
+
+// Extracts an entry within an archive, named in the form 'archive!entry', into the directory
+// 'dir', under a subdirectory named for the archive. Returns the pathname of the extracted file.
+func ExtractArchiveEntry(name, dir string) (extracted string, err error) {
+	archive, entry, inArchive := SplitArchiveName(name)
+	if !inArchive {
+		return "", errors.New("not a file within an archive: " + name)
+	}
+	cleaned := path.Clean("/" + entry)[1:]
+	if cleaned == "" {
+		return "", errors.New("invalid entry name in archive: " + name)
+	}
+	extracted = filepath.Join(dir, filepath.Base(archive), filepath.FromSlash(cleaned))
+	contents, err := readArchiveEntry(name)
+	if err != nil {
+		return
+	}
+	// A file left by an earlier run is reused only if it holds the same contents.
+	if existing, errE := os.ReadFile(extracted); errE == nil && bytes.Equal(existing, contents) {
+		return
+	}
+	if err = os.MkdirAll(filepath.Dir(extracted), 0777); err != nil {
+		return
+	}
+	err = os.WriteFile(extracted, contents, 0666)
+	return
+}
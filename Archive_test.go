@@ -0,0 +1,146 @@
+package CollageCreator
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var testArchiveEntries = []struct{ name, contents string }{
+	{"b.png", "second"},
+	{"a.png", "first"},
+	{"notes.txt", "not an image"},
+}
+
+func writeTestZip(t *testing.T, path string) {
+	fp, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fp.Close()
+	zw := zip.NewWriter(fp)
+	for _, e := range testArchiveEntries {
+		w, err := zw.Create(e.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(e.contents))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeTestTar(t *testing.T, path string, compressed bool) {
+	fp, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fp.Close()
+	var w io.Writer = fp
+	gz := gzip.NewWriter(fp)
+	if compressed {
+		w = gz
+	}
+	tw := tar.NewWriter(w)
+	for _, e := range testArchiveEntries {
+		tw.WriteHeader(&tar.Header{Name: "./" + e.name, Mode: 0644, Size: int64(len(e.contents)), Typeflag: tar.TypeReg})
+		tw.Write([]byte(e.contents))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if compressed {
+		if err := gz.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestArchiveEntries(t *testing.T) {
+	defer closeArchiveIndexes()
+	dir := t.TempDir()
+	zipPath, tarPath, tarGzPath := filepath.Join(dir, "images.zip"), filepath.Join(dir, "images.tar"), filepath.Join(dir, "images.tar.gz")
+	writeTestZip(t, zipPath)
+	writeTestTar(t, tarPath, false)
+	writeTestTar(t, tarGzPath, true)
+	for _, archive := range []string{zipPath, tarPath, tarGzPath} {
+		names, err := ListArchive(archive, "*.png")
+		if err != nil {
+			t.Fatalf("%s: %v", archive, err)
+		}
+		want := []string{archive + archiveEntrySeparator + "a.png", archive + archiveEntrySeparator + "b.png"}
+		if !reflect.DeepEqual(names, want) {
+			t.Errorf("%s: listed %v, want %v", archive, names, want)
+		}
+		for _, e := range testArchiveEntries {
+			contents, err := readArchiveEntry(archive + archiveEntrySeparator + e.name)
+			if err != nil || string(contents) != e.contents {
+				t.Errorf("%s: read %q, %v; want %q", e.name, contents, err, e.contents)
+			}
+		}
+		if _, err := readArchiveEntry(archive + archiveEntrySeparator + "missing.png"); err == nil {
+			t.Errorf("%s: reading a missing entry did not fail", archive)
+		}
+
+		extractDir := filepath.Join(dir, "extracted")
+		extracted, err := ExtractArchiveEntry(archive+archiveEntrySeparator+"a.png", extractDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(extracted, []byte("stale"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ExtractArchiveEntry(archive+archiveEntrySeparator+"a.png", extractDir); err != nil {
+			t.Fatal(err)
+		}
+		if contents, _ := os.ReadFile(extracted); string(contents) != "first" {
+			t.Errorf("%s: a stale extracted file was kept: %q", archive, contents)
+		}
+	}
+}
+
+func TestTarEntriesReadInPlace(t *testing.T) {
+	defer closeArchiveIndexes()
+	dir := t.TempDir()
+	tarGzPath := filepath.Join(dir, "images.tar.gz")
+	writeTestTar(t, tarGzPath, true)
+	index, err := indexArchive(tarGzPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, entry := range index.tarEntries {
+		if entry.contents != nil {
+			t.Errorf("%s: contents held in memory", name)
+		}
+	}
+	spooled := index.tarFile.Name()
+	if _, err := os.Stat(spooled); err != nil {
+		t.Fatalf("decompressed archive: %v", err)
+	}
+	closeArchiveIndexes()
+	if _, err := os.Stat(spooled); !os.IsNotExist(err) {
+		t.Errorf("decompressed archive %s was left behind: %v", spooled, err)
+	}
+}
+
+func TestRenderersShareExtractArchivesFlag(t *testing.T) {
+	saved := flag.CommandLine
+	defer func() { flag.CommandLine = saved }()
+	flag.CommandLine = flag.NewFlagSet("test", flag.PanicOnError)
+	parameters := newTestParameters()
+	CollageRenderer_SVG_Init().RegisterCustomParameters(parameters)
+	CollageRenderer_ImageMagickScript_Init().RegisterCustomParameters(parameters)
+	if flag.Lookup("extract-archives") == nil {
+		t.Error("-extract-archives was not registered")
+	}
+	if flag.Lookup("svg-extract-archives") == nil {
+		t.Error("-svg-extract-archives was not registered")
+	}
+}
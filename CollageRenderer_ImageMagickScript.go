@@ -1,10 +1,10 @@
 package CollageCreator
 
 import (
+	"flag"
 	"fmt"
 	"log"
 	"os"
-	"path/filepath"
 	"strings"
 )
 
@@ -26,8 +26,11 @@ func shellScriptDefang(str string) string {
 	return "'" + strings.ReplaceAll(str, "'", "'\"'\"'") + "'"
 }
 
-func createCollageImageMagickScript(imageLayout ImageLayout) string {
-	rv := ""
+func createCollageImageMagickScript(imageLayout ImageLayout) (rv string, err error) {
+	extractDir := ""
+	if extractDirI, valid := imageLayout.Parameters().Other(Archive_ExtractDir); valid {
+		extractDir, _ = extractDirI.(string)
+	}
 	xAdd := 0.0
 	yAdd := 0.0
 	xSize := 0.0
@@ -50,7 +53,7 @@ func createCollageImageMagickScript(imageLayout ImageLayout) string {
 	rv += "[ -z $OUTFILE ] && OUTFILE='Collage.jpg'\n"
 	rv += "[ -z $IM_CONVERT_BIN ] && IM_CONVERT_BIN='convert'\n"
 	rv += "[ -z $IM_COMPOSITE_BIN ] && IM_COMPOSITE_BIN='composite'\n"
-	rv += fmt.Sprintf("convert 'xc:black[%dx%d!]' -colorspace sRGB -type truecolor \"$OUTFILE\"\n\n", toInt(xSize), toInt(ySize))
+	rv += fmt.Sprintf("convert 'xc:black[%dx%d!]' -colorspace sRGB -type truecolor \"$OUTFILE\"\n\n", toIntP(xSize), toIntP(ySize))
 
 	i := 1
 	// TODO: This is extremely slow as it writes the entire canvas image once for each image placed onto it.
@@ -60,11 +63,15 @@ func createCollageImageMagickScript(imageLayout ImageLayout) string {
 		info := imageLayout.ImageInfoOf(img)
 		dimensions := info.DimensionsOf()
 		scaling := imageLayout.ScalingOf(img)
-		imageFile, err := filepath.Abs(imageLayout.ImageInfoOf(img).FileName())
-		if err != nil {
-			imageFile = imageLayout.ImageInfoOf(img).FileName()
+		var imageFile string
+		if imageFile, err = linkableImagePath(info, extractDir); err != nil {
+			imageLayout.Parameters().ProgressMonitor().ReportRenderingFailure()
+			return
 		}
 		rv += fmt.Sprintf("\"$IM_CONVERT_BIN\" %s", shellScriptDefang(imageFile))
+		if OrientationOf(info) > 1 {
+			rv += " -auto-orient"
+		}
 		// -scale %dx%d! -crop %dx%d+%d+%d - | \"$IM_COMPOSITE_BIN\" -compose atop -geometry +%d+%d - \"$OUTFILE\" \"$OUTFILE\"\n", toInt(dimensions.X()), toInt(dimensions.Y()))
 		if scaling.HasSize() {
 			dimensions = scaling.Scale(dimensions)
@@ -82,26 +89,39 @@ func createCollageImageMagickScript(imageLayout ImageLayout) string {
 		i++
 	}
 	imageLayout.Parameters().ProgressMonitor().ReportRenderingSuccess()
-	return rv
+	return
+}
+
+type CollageRenderer_ImageMagickScript_CustomParameters struct {
+	extractDir string
 }
 
 func CollageRenderer_ImageMagickScript_Init() CollageRenderer_ImageMagickScript {
-	return CollageRenderer_ImageMagickScript{}
+	return CollageRenderer_ImageMagickScript{new(CollageRenderer_ImageMagickScript_CustomParameters)}
 }
 
 // Produces output in the form of a Bash script that, when run, will call ImageMagick to produce
-// the output collage.
-type CollageRenderer_ImageMagickScript struct{}
+// the output collage. Images within archives are extracted so that the script can read them,
+// if a directory is given for them.
+type CollageRenderer_ImageMagickScript struct {
+	p *CollageRenderer_ImageMagickScript_CustomParameters
+}
 
 func (icr CollageRenderer_ImageMagickScript) RegisterCustomParameters(parameters *Parameters) bool {
+	flag.StringVar(&(icr.p.extractDir), "extract-archives", "", "(ImageMagick) "+archive_ExtractDirUsage)
 	return true
 }
 
 func (ict CollageRenderer_ImageMagickScript) ParseCustomParameters(parameters *Parameters) bool {
+	parameters.SetOther(Archive_ExtractDir, ict.p.extractDir)
 	return true
 }
 
 func (icr CollageRenderer_ImageMagickScript) CreateCollageImage(imageLayout ImageLayout) (oi OutputImage, err error) {
-	oi, err = OutputImage_ImageMagickScript{createCollageImageMagickScript(imageLayout)}, nil
+	contents, err := createCollageImageMagickScript(imageLayout)
+	if err != nil {
+		return
+	}
+	oi = OutputImage_ImageMagickScript{contents}
 	return
 }
@@ -1,6 +1,8 @@
 package CollageCreator
 
 import (
+	"errors"
+	"flag"
 	"image"
 	"image/draw"
 	"image/jpeg"
@@ -14,8 +16,12 @@ import (
 	"golang.org/x/image/tiff"
 )
 
+const (
+	RasterRenderer_Depth string = "RasterRenderer_Depth"
+)
+
 // Produces output in the form of a JPEG, PNG, or TIFF file, using Jan Schlicht's "resize" package
-// to handle scaling.
+// to handle scaling. PNG and TIFF files have 16 bits per channel if the image does.
 type OutputImage_image struct {
 	img image.Image
 }
@@ -74,7 +80,7 @@ func (oii OutputImage_image) WriteToFile(fileName string, parameters *Parameters
 	}
 }
 
-func createCollageImage(imageLayout ImageLayout) image.Image {
+func createCollageImage(imageLayout ImageLayout) (image.Image, error) {
 	xAdd := 0.0
 	yAdd := 0.0
 	xSize := 0.0
@@ -91,18 +97,48 @@ func createCollageImage(imageLayout ImageLayout) image.Image {
 			}
 		}
 	}
-	collageImage := image.NewNRGBA(image.Rect(0, 0, toInt(xSize), toInt(ySize)))
+	depth := 0
+	if depthI, valid := imageLayout.Parameters().Other(RasterRenderer_Depth); valid {
+		depth, _ = depthI.(int)
+	}
+	if depth == 0 {
+		depth = 8
+		for _, img := range imageLayout.Images(false) {
+			if BitDepthOf(imageLayout.ImageInfoOf(img)) > 8 {
+				depth = 16
+			}
+		}
+	}
+	var collageImage draw.Image
+	if depth > 8 {
+		collageImage = image.NewNRGBA64(image.Rect(0, 0, toIntP(xSize), toIntP(ySize)))
+	} else {
+		collageImage = image.NewNRGBA(image.Rect(0, 0, toIntP(xSize), toIntP(ySize)))
+	}
 
 	i := 1
 	for _, img := range imageLayout.Images(false) {
 		imageLayout.Parameters().ProgressMonitor().ReportRenderingProgress(i, imageLayout.PositionedImageCount())
 		info := imageLayout.ImageInfoOf(img)
-		imgData := info.ImageData().(image.Image)
+		if IsVectorImage(info) {
+			imageLayout.Parameters().ProgressMonitor().ReportRenderingFailure()
+			return nil, errors.New("vector input not rasterizable: " + info.FileName())
+		}
 		dimensions := info.DimensionsOf()
 		scaling := imageLayout.ScalingOf(img)
+		var imgData image.Image
+		var isImage bool
 		if scaling.HasSize() {
 			dimensions = scaling.Scale(dimensions)
-			imgData = resize.Resize(uint(toIntP(dimensions.X())), uint(toIntP(dimensions.Y())), imgData, resize.Lanczos3)
+			if imgData, isImage = ImageDataAtSize(info, dimensions).(image.Image); isImage {
+				imgData = resize.Resize(uint(toIntP(dimensions.X())), uint(toIntP(dimensions.Y())), imgData, resize.Lanczos3)
+			}
+		} else {
+			imgData, isImage = info.ImageData().(image.Image)
+		}
+		if !isImage {
+			imageLayout.Parameters().ProgressMonitor().ReportRenderingFailure()
+			return nil, errors.New("image data not rasterizable: " + info.FileName())
 		}
 		cropping := imageLayout.CroppingOf(img)
 		offset := Dims{0, 0}
@@ -111,31 +147,52 @@ func createCollageImage(imageLayout ImageLayout) image.Image {
 			dimensions = cropping.Crop(dimensions)
 		}
 		position := imageLayout.PositionOf(img)
-		positionRect := image.Rect(toInt(position.X()), toInt(position.Y()), toIntP(position.X()+dimensions.X()), toIntP(position.Y()+dimensions.Y()))
-		draw.Draw(collageImage, positionRect, imgData, image.Point{toInt(offset.X()), toInt(offset.Y())}, draw.Src)
+		positionRect := image.Rect(toIntP(position.X()), toIntP(position.Y()), toIntP(position.X()+dimensions.X()), toIntP(position.Y()+dimensions.Y()))
+		// The image need not have its origin at (0, 0), as a sub-image does not.
+		source := imgData.Bounds().Min.Add(image.Point{toIntP(offset.X()), toIntP(offset.Y())})
+		draw.Draw(collageImage, positionRect, imgData, source, draw.Src)
 		i++
 	}
 	imageLayout.Parameters().ProgressMonitor().ReportRenderingSuccess()
-	return collageImage
+	return collageImage, nil
+}
+
+type CollageRenderer_Raster_CustomParameters struct {
+	depth int
 }
 
 func CollageRenderer_Raster_Init() CollageRenderer_Raster {
-	return CollageRenderer_Raster{}
+	return CollageRenderer_Raster{new(CollageRenderer_Raster_CustomParameters)}
 }
 
 // Produces output in the form of a JPEG, PNG, or TIFF file, using Jan Schlicht's "resize" package
-// to handle scaling.
-type CollageRenderer_Raster struct{}
+// to handle scaling. The collage is composited with 16 bits per channel if any input image has
+// them, or if the user asks for it, so that 16-bit PNG and TIFF files can be written.
+type CollageRenderer_Raster struct {
+	p *CollageRenderer_Raster_CustomParameters
+}
 
 func (icr CollageRenderer_Raster) RegisterCustomParameters(parameters *Parameters) bool {
+	flag.IntVar(&(icr.p.depth), "depth", 0, "Bits per channel of the output image: 8 or 16 (0: 16 if any input image has 16, 8 otherwise)")
 	return true
 }
 
 func (ict CollageRenderer_Raster) ParseCustomParameters(parameters *Parameters) bool {
+	switch ict.p.depth {
+	case 0, 8, 16:
+		parameters.SetOther(RasterRenderer_Depth, ict.p.depth)
+	default:
+		parameters.ProgressMonitor().ReportMessage("-depth value must be 8 or 16")
+		return false
+	}
 	return true
 }
 
 func (icr CollageRenderer_Raster) CreateCollageImage(imageLayout ImageLayout) (oi OutputImage, err error) {
-	oi, err = OutputImage_image{createCollageImage(imageLayout)}, nil
+	img, err := createCollageImage(imageLayout)
+	if err != nil {
+		return
+	}
+	oi = OutputImage_image{img}
 	return
 }
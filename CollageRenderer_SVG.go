@@ -1,10 +1,10 @@
 package CollageCreator
 
 import (
+	"flag"
 	"fmt"
 	"log"
 	"os"
-	"path/filepath"
 )
 
 // Produces output in the form of an SVG file that links to all the input images.
@@ -20,8 +20,18 @@ func (ois OutputImage_SVG) WriteToFile(fileName string, parameters *Parameters)
 	parameters.ProgressMonitor().ReportOutputSuccess(fileName)
 }
 
-func createCollageSVG(imageLayout ImageLayout) string {
-	rv := ""
+// Generates an 'image' tag placing an image, stored in the given EXIF orientation,
+// at 'origin' with the given dimensions as displayed.
+func svgOrientedImageTag(origin, dimensions Dims, orientation int, imageURI string) string {
+	stored := OrientedDims(dimensions, orientation)
+	return fmt.Sprintf("<image x=\"0\" y=\"0\" width=\"%f\" height=\"%f\" transform=\"%s\" style=\"image-orientation: none\" xlink:href=\"%s\"/>", stored.X(), stored.Y(), svgOrientationTransform(origin, dimensions, orientation), imageURI)
+}
+
+func createCollageSVG(imageLayout ImageLayout) (rv string, err error) {
+	extractDir := ""
+	if extractDirI, valid := imageLayout.Parameters().Other(Archive_ExtractDir); valid {
+		extractDir, _ = extractDirI.(string)
+	}
 	xAdd := 0.0
 	yAdd := 0.0
 	xSize := 0.0
@@ -54,17 +64,35 @@ func createCollageSVG(imageLayout ImageLayout) string {
 		if scaling.HasSize() {
 			dimensions = scaling.Scale(dimensions)
 		}
-		imagePath, errI := filepath.Abs(imageLayout.ImageInfoOf(img).FileName())
-		if errI != nil {
-			imagePath = imageLayout.ImageInfoOf(img).FileName()
+		var imagePath string
+		if encoded, ok := UnwrapImageInfo(imageInfo).(EncodedImageInfo); ok && extractDir == "" {
+			imagePath, err = imageDataURI(encoded)
+		} else if imagePath, err = linkableImagePath(imageInfo, extractDir); err == nil {
+			imagePath = "file:///" + imagePath
+		}
+		if err != nil {
+			imageLayout.Parameters().ProgressMonitor().ReportRenderingFailure()
+			return
+		}
+		orientation := OrientationOf(imageInfo)
+		aspectAttr := ""
+		if IsVectorImage(imageInfo) {
+			// Stretch the image to fill its box exactly, as its scaling may change its aspect ratio.
+			aspectAttr = " preserveAspectRatio=\"none\""
 		}
 		if cropping.HasOffset() {
 			croppedDimensions := cropping.Crop(dimensions)
 			offset := cropping.Offset(dimensions)
 			clipPaths += fmt.Sprintf("  <clipPath id=\"clip%d\"><rect x=\"%f\" y=\"%f\" width=\"%f\" height=\"%f\"/></clipPath>\n", i, position.X(), -(ySize - position.Y()), croppedDimensions.X(), croppedDimensions.Y())
-			imageTags += fmt.Sprintf("  <image x=\"%f\" y=\"%f\" width=\"%f\" height=\"%f\"  clip-path=\"url(#clip%d)\" xlink:href=\"file:///%s\"/>\n", position.X()-offset.X(), -(ySize-position.Y())+offset.Y(), dimensions.X(), dimensions.Y(), i, imagePath)
+			if orientation > 1 {
+				imageTags += fmt.Sprintf("  <g clip-path=\"url(#clip%d)\">%s</g>\n", i, svgOrientedImageTag(NewDims(position.X()-offset.X(), -(ySize-position.Y())+offset.Y()), dimensions, orientation, imagePath))
+			} else {
+				imageTags += fmt.Sprintf("  <image x=\"%f\" y=\"%f\" width=\"%f\" height=\"%f\"  clip-path=\"url(#clip%d)\"%s xlink:href=\"%s\"/>\n", position.X()-offset.X(), -(ySize-position.Y())+offset.Y(), dimensions.X(), dimensions.Y(), i, aspectAttr, imagePath)
+			}
+		} else if orientation > 1 {
+			imageTags += fmt.Sprintf("  %s\n", svgOrientedImageTag(NewDims(position.X(), -(ySize-position.Y())), dimensions, orientation, imagePath))
 		} else {
-			imageTags += fmt.Sprintf("  <image x=\"%f\" y=\"%f\" width=\"%f\" height=\"%f\" xlink:href=\"file:///%s\"/>\n", position.X(), -(ySize - position.Y()), dimensions.X(), dimensions.Y(), imagePath)
+			imageTags += fmt.Sprintf("  <image x=\"%f\" y=\"%f\" width=\"%f\" height=\"%f\"%s xlink:href=\"%s\"/>\n", position.X(), -(ySize - position.Y()), dimensions.X(), dimensions.Y(), aspectAttr, imagePath)
 		}
 
 		i++
@@ -73,25 +101,39 @@ func createCollageSVG(imageLayout ImageLayout) string {
 	rv += imageTags
 	rv += "</svg>\n"
 	imageLayout.Parameters().ProgressMonitor().ReportRenderingSuccess()
-	return rv
+	return
+}
+
+type CollageRenderer_SVG_CustomParameters struct {
+	extractDir string
 }
 
 func CollageRenderer_SVG_Init() CollageRenderer_SVG {
-	return CollageRenderer_SVG{}
+	return CollageRenderer_SVG{new(CollageRenderer_SVG_CustomParameters)}
 }
 
-// Produces output in the form of an SVG file that links to all the input images.
-type CollageRenderer_SVG struct{}
+// Produces output in the form of an SVG file that links to all the input images. Images within
+// archives are extracted so that they can be linked to, if a directory is given for them; images
+// held in memory are embedded unless such a directory is given.
+type CollageRenderer_SVG struct {
+	p *CollageRenderer_SVG_CustomParameters
+}
 
 func (icr CollageRenderer_SVG) RegisterCustomParameters(parameters *Parameters) bool {
+	flag.StringVar(&(icr.p.extractDir), "svg-extract-archives", "", "(SVG) "+archive_ExtractDirUsage)
 	return true
 }
 
 func (ict CollageRenderer_SVG) ParseCustomParameters(parameters *Parameters) bool {
+	parameters.SetOther(Archive_ExtractDir, ict.p.extractDir)
 	return true
 }
 
 func (icr CollageRenderer_SVG) CreateCollageImage(imageLayout ImageLayout) (oi OutputImage, err error) {
-	oi, err = OutputImage_SVG{createCollageSVG(imageLayout)}, nil
+	contents, err := createCollageSVG(imageLayout)
+	if err != nil {
+		return
+	}
+	oi = OutputImage_SVG{contents}
 	return
 }
@@ -0,0 +1,410 @@
+// This file contains auxiliary methods that normalize the colours of input
+// images to sRGB, the colour space in which they are composited. CMYK and
+// YCCK JPEGs, including those without an Adobe APP14 segment, are converted
+// to RGB; images with an embedded ICC profile built from primaries and tone
+// curves (such as Adobe RGB or Display P3) are converted from that profile.
+// Profiles built from lookup tables, and CMYK profiles, are not applied.
+package CollageCreator
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"io"
+	"math"
+	"strings"
+	"sync"
+)
+
+// The matrix from linear sRGB to the ICC profile connection space (XYZ, adapted to D50).
+var sRGBToXYZD50 = [3][3]float64{
+	{0.4360747, 0.3850649, 0.1430804},
+	{0.2225045, 0.7168786, 0.0606169},
+	{0.0139322, 0.0971045, 0.7141733},
+}
+
+// The matrix from the ICC profile connection space to linear sRGB.
+var xyzD50ToSRGB = [3][3]float64{
+	{3.1338561, -1.6168667, -0.4906146},
+	{-0.9787684, 1.9161415, 0.0334540},
+	{0.0719453, -0.2289914, 1.4052427},
+}
+
+// Converts a linear sRGB value to its gamma-encoded form.
+func sRGBEncode(v float64) float64 {
+	if v <= 0.0031308 {
+		return 12.92 * v
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
+
+// Converts a gamma-encoded sRGB value to its linear form.
+func sRGBDecode(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// A tone curve from an ICC profile, mapping an encoded value in [0, 1] to a linear one.
+type iccCurve func(float64) float64
+
+// A conversion to sRGB from an RGB colour space defined by an ICC profile.
+type colorTransform struct {
+	curves [3]iccCurve
+	// The matrix from the profile's linear RGB to linear sRGB.
+	matrix [3][3]float64
+	once   sync.Once
+	// The linear value of each 16-bit encoded value, for each channel.
+	linear [3][]float64
+	// The 16-bit sRGB value of each of 4096 steps of linear values.
+	encoded []uint16
+}
+
+// Builds the lookup tables of a colour transform.
+func (ct *colorTransform) buildTables() {
+	for c := 0; c < 3; c++ {
+		ct.linear[c] = make([]float64, 65536)
+		for v := range ct.linear[c] {
+			ct.linear[c][v] = ct.curves[c](float64(v) / 65535)
+		}
+	}
+	ct.encoded = make([]uint16, 4097)
+	for i := range ct.encoded {
+		ct.encoded[i] = uint16(math.Round(sRGBEncode(float64(i)/4096) * 65535))
+	}
+}
+
+// Converts an encoded colour in the profile's colour space to an encoded sRGB colour.
+func (ct *colorTransform) convert(r, g, b uint16) (uint16, uint16, uint16) {
+	in := [3]float64{ct.linear[0][r], ct.linear[1][g], ct.linear[2][b]}
+	var out [3]uint16
+	for c := 0; c < 3; c++ {
+		v := ct.matrix[c][0]*in[0] + ct.matrix[c][1]*in[1] + ct.matrix[c][2]*in[2]
+		out[c] = ct.encoded[int(math.Round(math.Max(0, math.Min(1, v))*4096))]
+	}
+	return out[0], out[1], out[2]
+}
+
+// How the colours of an input image are to be normalized.
+type colorManagement struct {
+	// The conversion from the image's ICC profile, or nil if it has none or needs none.
+	transform *colorTransform
+}
+
+// Multiplies two 3x3 matrices.
+func multiplyMatrices(a, b [3][3]float64) (rv [3][3]float64) {
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				rv[i][j] += a[i][k] * b[k][j]
+			}
+		}
+	}
+	return
+}
+
+// Reads a signed 15.16 fixed-point number.
+func s15Fixed16(data []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(data))) / 65536
+}
+
+// Parses a 'curv' or 'para' tone curve from an ICC profile.
+func parseICCCurve(data []byte) (iccCurve, error) {
+	if len(data) < 12 {
+		return nil, errors.New("truncated tone curve")
+	}
+	switch string(data[:4]) {
+	case "curv":
+		count := int(binary.BigEndian.Uint32(data[8:]))
+		if len(data) < 12+2*count {
+			return nil, errors.New("truncated tone curve")
+		}
+		switch count {
+		case 0:
+			return func(v float64) float64 { return v }, nil
+		case 1:
+			gamma := float64(binary.BigEndian.Uint16(data[12:])) / 256
+			return func(v float64) float64 { return math.Pow(v, gamma) }, nil
+		}
+		table := make([]float64, count)
+		for i := range table {
+			table[i] = float64(binary.BigEndian.Uint16(data[12+2*i:])) / 65535
+		}
+		return func(v float64) float64 {
+			pos := v * float64(count-1)
+			i := int(pos)
+			if i >= count-1 {
+				return table[count-1]
+			}
+			return table[i] + (table[i+1]-table[i])*(pos-float64(i))
+		}, nil
+	case "para":
+		paramCounts := []int{1, 3, 4, 5, 7}
+		function := int(binary.BigEndian.Uint16(data[8:]))
+		if function >= len(paramCounts) || len(data) < 12+4*paramCounts[function] {
+			return nil, errors.New("unsupported parametric tone curve")
+		}
+		// g, a, b, c, d, e, f, as named in the ICC specification.
+		p := []float64{1, 1, 0, 0, 0, 0, 0}
+		for i := 0; i < paramCounts[function]; i++ {
+			p[i] = s15Fixed16(data[12+4*i:])
+		}
+		g, a, b, c, d, e, f := p[0], p[1], p[2], p[3], p[4], p[5], p[6]
+		// Functions 1 and 2 begin where a*v+b reaches zero, which they cannot do if 'a' is zero.
+		if (function == 1 || function == 2) && a == 0 {
+			return nil, errors.New("degenerate parametric tone curve")
+		}
+		switch function {
+		case 1:
+			d = -b / a
+		case 2:
+			d, e, f = -b/a, c, c
+			c = 0
+		}
+		return func(v float64) float64 {
+			if function > 0 && v < d {
+				return c*v + f
+			}
+			return math.Pow(math.Max(0, a*v+b), g) + e
+		}, nil
+	}
+	return nil, errors.New("unsupported tone curve type '" + string(data[:4]) + "'")
+}
+
+// Parses an ICC profile. Returns a nil transform if the profile needs no conversion, being
+// sRGB itself or not an RGB profile, or an error if it is a profile that cannot be applied.
+func parseICCProfile(data []byte) (*colorTransform, error) {
+	if len(data) < 132 || string(data[36:40]) != "acsp" {
+		return nil, errors.New("malformed ICC profile")
+	}
+	switch space := string(data[16:20]); space {
+	case "RGB ":
+	case "GRAY":
+		return nil, nil
+	default:
+		return nil, errors.New("ICC profiles for the '" + strings.TrimSpace(space) + "' colour space are not supported")
+	}
+	tags := map[string][]byte{}
+	count := int(binary.BigEndian.Uint32(data[128:]))
+	for i := 0; i < count && 144+12*i <= len(data); i++ {
+		entry := data[132+12*i:]
+		offset, size := binary.BigEndian.Uint32(entry[4:]), binary.BigEndian.Uint32(entry[8:])
+		if uint64(offset)+uint64(size) <= uint64(len(data)) {
+			tags[string(entry[:4])] = data[offset : offset+size]
+		}
+	}
+	var toXYZ [3][3]float64
+	ct := new(colorTransform)
+	for c, prefix := range []string{"r", "g", "b"} {
+		xyz, trc := tags[prefix+"XYZ"], tags[prefix+"TRC"]
+		if len(xyz) < 20 || string(xyz[:4]) != "XYZ " || trc == nil {
+			return nil, errors.New("ICC profiles based on lookup tables are not supported")
+		}
+		for i := 0; i < 3; i++ {
+			toXYZ[i][c] = s15Fixed16(xyz[8+4*i:])
+		}
+		curve, err := parseICCCurve(trc)
+		if err != nil {
+			return nil, err
+		}
+		ct.curves[c] = curve
+	}
+	ct.matrix = multiplyMatrices(xyzD50ToSRGB, toXYZ)
+	// Recognize an sRGB profile, which needs no conversion, by its primaries and tone curves.
+	isSRGB := true
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			if math.Abs(toXYZ[i][j]-sRGBToXYZD50[i][j]) > 0.002 {
+				isSRGB = false
+			}
+		}
+		for _, v := range []float64{0.02, 0.2, 0.5, 0.8} {
+			if math.Abs(ct.curves[i](v)-sRGBDecode(v)) > 0.005 {
+				isSRGB = false
+			}
+		}
+	}
+	if isSRGB {
+		return nil, nil
+	}
+	return ct, nil
+}
+
+// Reads the ICC profile embedded in a JPEG file, which may be split across several APP2
+// segments.
+func readJPEGICCProfile(r io.ReaderAt) []byte {
+	const marker = "ICC_PROFILE\x00"
+	chunks := map[int][]byte{}
+	total := 0
+	var offset int64 = 2
+	segment := make([]byte, 4)
+	for {
+		if n, _ := r.ReadAt(segment, offset); n < 4 || segment[0] != 0xff {
+			break
+		}
+		kind, length := segment[1], int64(binary.BigEndian.Uint16(segment[2:]))
+		if kind == 0xda || kind == 0xd9 || length < 2 {
+			break
+		}
+		if kind == 0xe2 && length > int64(len(marker))+4 {
+			data := make([]byte, length-2)
+			if n, _ := r.ReadAt(data, offset+4); n == len(data) && string(data[:len(marker)]) == marker {
+				chunks[int(data[len(marker)])] = data[len(marker)+2:]
+				total = int(data[len(marker)+1])
+			}
+		}
+		offset += 2 + length
+	}
+	if total == 0 || len(chunks) != total {
+		return nil
+	}
+	var rv []byte
+	for i := 1; i <= total; i++ {
+		chunk, found := chunks[i]
+		if !found {
+			return nil
+		}
+		rv = append(rv, chunk...)
+	}
+	return rv
+}
+
+// Reads the ICC profile embedded in the 'iCCP' chunk of a PNG file.
+func readPNGICCProfile(r io.ReaderAt) []byte {
+	var offset int64 = 8
+	header := make([]byte, 8)
+	for {
+		if n, _ := r.ReadAt(header, offset); n < 8 {
+			return nil
+		}
+		length, kind := int64(binary.BigEndian.Uint32(header)), string(header[4:])
+		switch kind {
+		case "iCCP":
+			data := make([]byte, length)
+			if n, _ := r.ReadAt(data, offset+8); int64(n) < length {
+				return nil
+			}
+			// The profile's name, a null separator, and the compression method precede it.
+			nul := bytes.IndexByte(data, 0)
+			if nul < 0 || nul+2 > len(data) {
+				return nil
+			}
+			zr, err := zlib.NewReader(bytes.NewReader(data[nul+2:]))
+			if err != nil {
+				return nil
+			}
+			defer zr.Close()
+			rv, err := io.ReadAll(zr)
+			if err != nil {
+				return nil
+			}
+			return rv
+		case "IDAT", "IEND":
+			return nil
+		}
+		offset += 12 + length
+	}
+}
+
+// Determines how the colours of an image are to be normalized, from its embedded ICC profile.
+// Returns a description of the problem if the image has a profile that cannot be applied.
+func inspectImageColor(r io.ReaderAt) (cm colorManagement, issue string) {
+	header := make([]byte, 16)
+	n, _ := r.ReadAt(header, 0)
+	var profile []byte
+	switch detectImageFormat(header[:n]) {
+	case "JPEG":
+		profile = readJPEGICCProfile(r)
+	case "PNG":
+		profile = readPNGICCProfile(r)
+	}
+	if profile == nil {
+		return
+	}
+	var err error
+	if cm.transform, err = parseICCProfile(profile); err != nil {
+		issue = err.Error()
+	}
+	return
+}
+
+// Decodes a 4-component JPEG that lacks the Adobe APP14 segment naming its colour transform,
+// which Go's decoder rejects, as plain CMYK. An APP14 segment is inserted to make it decodable;
+// as the decoder then expects the channels to be inverted, as Adobe stores them, the channels
+// are inverted back.
+func decodeUnmarkedCMYK(reader io.ReaderAt) (image.Image, error) {
+	var data bytes.Buffer
+	if _, err := io.Copy(&data, io.NewSectionReader(reader, 0, math.MaxInt64)); err != nil {
+		return nil, err
+	}
+	if data.Len() < 2 {
+		return nil, errors.New("truncated JPEG")
+	}
+	app14 := []byte{0xff, 0xee, 0x00, 0x0e, 'A', 'd', 'o', 'b', 'e', 0x00, 0x64, 0x00, 0x00, 0x00, 0x00, 0x00}
+	patched := append(append(append([]byte{}, data.Bytes()[:2]...), app14...), data.Bytes()[2:]...)
+	img, err := jpeg.Decode(bytes.NewReader(patched))
+	if err != nil {
+		return nil, err
+	}
+	if cmyk, ok := img.(*image.CMYK); ok {
+		for i := range cmyk.Pix {
+			cmyk.Pix[i] = 255 - cmyk.Pix[i]
+		}
+	}
+	return img, nil
+}
+
+// Normalizes the colours of a decoded image to sRGB: CMYK images are converted to RGB, and
+// images in another RGB colour space are converted from it.
+func normalizeImageColor(img image.Image, cm colorManagement) image.Image {
+	bounds := img.Bounds()
+	if cm.transform == nil {
+		if _, isCMYK := img.(*image.CMYK); isCMYK {
+			rv := image.NewNRGBA(bounds)
+			draw.Draw(rv, bounds, img, bounds.Min, draw.Src)
+			return rv
+		}
+		return img
+	}
+	ct := cm.transform
+	ct.once.Do(ct.buildTables)
+	switch img.ColorModel() {
+	case color.RGBA64Model, color.NRGBA64Model, color.Gray16Model:
+		rv := image.NewNRGBA64(bounds)
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				c := color.NRGBA64Model.Convert(img.At(x, y)).(color.NRGBA64)
+				c.R, c.G, c.B = ct.convert(c.R, c.G, c.B)
+				rv.SetNRGBA64(x, y, c)
+			}
+		}
+		return rv
+	}
+	rv := image.NewNRGBA(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			r, g, b := ct.convert(uint16(c.R)*0x101, uint16(c.G)*0x101, uint16(c.B)*0x101)
+			rv.SetNRGBA(x, y, color.NRGBA{uint8((uint32(r) + 128) / 257), uint8((uint32(g) + 128) / 257), uint8((uint32(b) + 128) / 257), c.A})
+		}
+	}
+	return rv
+}
+
+// Decodes an image and normalizes its colours to sRGB.
+func decodeImage(reader imageSource, cm colorManagement) (image.Image, error) {
+	img, _, err := image.Decode(reader)
+	if _, unsupported := err.(jpeg.UnsupportedError); unsupported && strings.Contains(err.Error(), "APP14") {
+		img, err = decodeUnmarkedCMYK(reader)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return normalizeImageColor(img, cm), nil
+}
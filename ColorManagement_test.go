@@ -0,0 +1,196 @@
+package CollageCreator
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"math"
+	"strings"
+	"testing"
+)
+
+// Appends a big-endian 32-bit integer.
+func appendUint32(data []byte, v uint32) []byte {
+	var encoded [4]byte
+	binary.BigEndian.PutUint32(encoded[:], v)
+	return append(data, encoded[:]...)
+}
+
+// Encodes a 'curv' tone curve of a single gamma.
+func gammaCurve(gamma float64) []byte {
+	curve := []byte("curv\x00\x00\x00\x00\x00\x00\x00\x01")
+	return append(curve, byte(uint16(math.Round(gamma*256))>>8), byte(uint16(math.Round(gamma*256))))
+}
+
+// Encodes a 'para' tone curve of the given function and parameters.
+func parametricCurve(function uint16, params ...float64) []byte {
+	curve := []byte("para\x00\x00\x00\x00")
+	curve = append(curve, byte(function>>8), byte(function), 0, 0)
+	for _, p := range params {
+		curve = appendUint32(curve, uint32(int32(math.Round(p*65536))))
+	}
+	return curve
+}
+
+// The tone curve of sRGB, as a 'para' curve.
+var sRGBCurve = parametricCurve(3, 2.4, 1/1.055, 0.055/1.055, 1/12.92, 0.04045)
+
+// Encodes an ICC profile for the given colour space, with primaries given as the columns of
+// a matrix to XYZ and the same tone curve for every channel.
+func iccProfile(space string, toXYZ [3][3]float64, trc []byte) []byte {
+	var tagData []byte
+	var tagTable []byte
+	addTag := func(signature string, data []byte) {
+		tagTable = append(tagTable, signature...)
+		tagTable = appendUint32(tagTable, uint32(0))
+		tagTable = appendUint32(tagTable, uint32(len(data)))
+		tagData = append(tagData, data...)
+	}
+	for c, prefix := range []string{"r", "g", "b"} {
+		xyz := []byte("XYZ \x00\x00\x00\x00")
+		for i := 0; i < 3; i++ {
+			xyz = appendUint32(xyz, uint32(int32(math.Round(toXYZ[i][c]*65536))))
+		}
+		addTag(prefix+"XYZ", xyz)
+		addTag(prefix+"TRC", trc)
+	}
+	count := len(tagTable) / 12
+	start := 132 + len(tagTable)
+	offset := start
+	for i := 0; i < count; i++ {
+		binary.BigEndian.PutUint32(tagTable[12*i+4:], uint32(offset))
+		offset += int(binary.BigEndian.Uint32(tagTable[12*i+8:]))
+	}
+	header := make([]byte, 128)
+	binary.BigEndian.PutUint32(header, uint32(start+len(tagData)))
+	copy(header[12:], "mntr")
+	copy(header[16:], space)
+	copy(header[20:], "XYZ ")
+	copy(header[36:], "acsp")
+	profile := appendUint32(header, uint32(count))
+	return append(append(profile, tagTable...), tagData...)
+}
+
+// The primaries of Adobe RGB (1998), adapted to D50.
+var adobeRGBToXYZD50 = [3][3]float64{
+	{0.6097559, 0.2052401, 0.1492240},
+	{0.3111242, 0.6256560, 0.0632197},
+	{0.0194811, 0.0608902, 0.7448387},
+}
+
+// Encodes a JPEG image carrying the given ICC profile in a single APP2 segment.
+func jpegWithICCProfile(t *testing.T, profile []byte) []byte {
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, image.NewRGBA(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatal(err)
+	}
+	app2 := append([]byte("ICC_PROFILE\x00\x01\x01"), profile...)
+	segment := append([]byte{0xFF, 0xE2, byte((len(app2) + 2) >> 8), byte(len(app2) + 2)}, app2...)
+	data := encoded.Bytes()
+	return append(append(append([]byte{}, data[:2]...), segment...), data[2:]...)
+}
+
+func TestParseICCCurve(t *testing.T) {
+	cases := []struct {
+		name  string
+		data  []byte
+		at    float64
+		want  float64
+		valid bool
+	}{
+		{"identity", []byte("curv\x00\x00\x00\x00\x00\x00\x00\x00"), 0.3, 0.3, true},
+		{"gamma", gammaCurve(2.2), 0.5, math.Pow(0.5, 563.0/256), true},
+		{"table", []byte("curv\x00\x00\x00\x00\x00\x00\x00\x02\x00\x00\xff\xff"), 0.25, 0.25, true},
+		{"sRGB", sRGBCurve, 0.5, sRGBDecode(0.5), true},
+		{"sRGB toe", sRGBCurve, 0.02, sRGBDecode(0.02), true},
+		{"function 1", parametricCurve(1, 2, 1, -0.5), 0.75, 0.0625, true},
+		{"function 1 below the threshold", parametricCurve(1, 2, 1, -0.5), 0.25, 0, true},
+		{"function 1 with a of zero", parametricCurve(1, 2, 0, 0.5), 0, 0, false},
+		{"function 2 with a of zero", parametricCurve(2, 2, 0, 0.5, 0.1), 0, 0, false},
+		{"unknown function", parametricCurve(5, 1), 0, 0, false},
+		{"truncated table", []byte("curv\x00\x00\x00\x00\x00\x00\x00\x04\x00\x00"), 0, 0, false},
+		{"truncated header", []byte("curv\x00\x00"), 0, 0, false},
+		{"unknown type", []byte("mft2\x00\x00\x00\x00\x00\x00\x00\x00"), 0, 0, false},
+	}
+	for _, c := range cases {
+		curve, err := parseICCCurve(c.data)
+		if !c.valid {
+			if err == nil {
+				t.Errorf("%s: parsed without error", c.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		assertNear(t, c.name, curve(c.at), c.want, 1e-4)
+	}
+}
+
+func TestParseICCProfile(t *testing.T) {
+	adobeRGB := iccProfile("RGB ", adobeRGBToXYZD50, gammaCurve(2.2))
+	cases := []struct {
+		name string
+		data []byte
+		// Whether the profile needs a conversion.
+		transform bool
+		// Part of the error expected, or "" if none is.
+		err string
+	}{
+		{"sRGB", iccProfile("RGB ", sRGBToXYZD50, sRGBCurve), false, ""},
+		{"Adobe RGB", adobeRGB, true, ""},
+		{"greyscale", iccProfile("GRAY", sRGBToXYZD50, sRGBCurve), false, ""},
+		{"CMYK", iccProfile("CMYK", sRGBToXYZD50, sRGBCurve), false, "'CMYK' colour space"},
+		{"truncated header", adobeRGB[:100], false, "malformed"},
+		{"truncated tags", adobeRGB[:200], false, "lookup tables"},
+		{"no signature", append(append([]byte{}, adobeRGB[:36]...), adobeRGB[40:]...), false, "malformed"},
+	}
+	for _, c := range cases {
+		ct, err := parseICCProfile(c.data)
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%s: error %v, want one mentioning %q", c.name, err, c.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if (ct != nil) != c.transform {
+			t.Errorf("%s: transform %v, want one: %v", c.name, ct, c.transform)
+		}
+	}
+
+	ct, err := parseICCProfile(adobeRGB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ct.once.Do(ct.buildTables)
+	for _, v := range []float64{1, 0.5} {
+		encoded := uint16(v * 65535)
+		r, g, b := ct.convert(encoded, encoded, encoded)
+		want := sRGBEncode(math.Pow(v, 563.0/256)) * 65535
+		for _, got := range []uint16{r, g, b} {
+			assertNear(t, "Adobe RGB grey in sRGB", float64(got), want, 65535*0.005)
+		}
+	}
+	r, g, _ := ct.convert(0, 65535, 0)
+	if r != 0 || g != 65535 {
+		t.Errorf("Adobe RGB green converted to (%d, %d), want it clipped to sRGB green", r, g)
+	}
+}
+
+func TestInspectImageColorReportsCMYKProfile(t *testing.T) {
+	data := jpegWithICCProfile(t, iccProfile("CMYK", sRGBToXYZD50, sRGBCurve))
+	cm, issue := inspectImageColor(bytes.NewReader(data))
+	if cm.transform != nil || !strings.Contains(issue, "CMYK") {
+		t.Errorf("transform %v, issue %q; want an issue naming CMYK", cm.transform, issue)
+	}
+	data = jpegWithICCProfile(t, iccProfile("RGB ", adobeRGBToXYZD50, gammaCurve(2.2)))
+	if cm, issue = inspectImageColor(bytes.NewReader(data)); cm.transform == nil || issue != "" {
+		t.Errorf("Adobe RGB: transform %v, issue %q", cm.transform, issue)
+	}
+}
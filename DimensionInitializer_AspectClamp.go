@@ -0,0 +1,150 @@
+package CollageCreator
+
+import (
+	"errors"
+	"flag"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	AspectClamp_MinAspect string = "AspectClamp_MinAspect"
+	AspectClamp_MaxAspect string = "AspectClamp_MaxAspect"
+	AspectClamp_Gravity   string = "AspectClamp_Gravity"
+)
+
+// The position of a crop window within an image, as fractions of the space left over
+// horizontally and vertically (0 placing the window at the top or left, 1 at the bottom or right).
+type Gravity struct {
+	x, y float64
+}
+
+var gravities map[string]Gravity = map[string]Gravity{
+	"northwest": {0, 0}, "north": {0.5, 0}, "northeast": {1, 0},
+	"west": {0, 0.5}, "center": {0.5, 0.5}, "east": {1, 0.5},
+	"southwest": {0, 1}, "south": {0.5, 1}, "southeast": {1, 1},
+}
+
+// Parses an ImageMagick-style gravity name (e.g., 'center', 'north', 'southeast').
+func ParseGravity(arg string) (g Gravity, err error) {
+	g, valid := gravities[strings.ToLower(arg)]
+	if !valid {
+		err = errors.New("Unknown gravity: '" + arg + "'")
+	}
+	return
+}
+
+// Calculates the offset of a window of the given size placed within an image according to this gravity.
+func (g Gravity) Offset(fullSize, windowSize Dims) Dims {
+	return NewDims(math.Round((fullSize.X()-windowSize.X())*g.x), math.Round((fullSize.Y()-windowSize.Y())*g.y))
+}
+
+// Parses an aspect ratio given either as a number or in the form 'WxH' or 'W:H'.
+// An empty string yields 0, signifying no limit.
+func ParseAspectRatio(arg string) (ratio float64, err error) {
+	if arg == "" {
+		return 0, nil
+	}
+	if parts := strings.SplitN(strings.ReplaceAll(arg, ":", "x"), "x", 2); len(parts) == 2 {
+		var w, h float64
+		if w, err = strconv.ParseFloat(parts[0], 64); err == nil {
+			h, err = strconv.ParseFloat(parts[1], 64)
+		}
+		if err == nil && w > 0 && h > 0 {
+			return w / h, nil
+		}
+	} else if ratio, err = strconv.ParseFloat(arg, 64); err == nil && ratio > 0 {
+		return ratio, nil
+	}
+	return 0, errors.New("Malformed aspect ratio: '" + arg + "'")
+}
+
+// Calculates the largest window of an image of the given size whose aspect ratio lies
+// within the given bounds (0 signifying no bound).
+func clampedWindowSize(fullSize Dims, minAspect, maxAspect float64) Dims {
+	aspect := fullSize.X() / fullSize.Y()
+	if maxAspect > 0 && aspect > maxAspect {
+		return NewDims(math.Round(fullSize.Y()*maxAspect), fullSize.Y())
+	} else if minAspect > 0 && aspect < minAspect {
+		return NewDims(fullSize.X(), math.Round(fullSize.X()/minAspect))
+	}
+	return fullSize
+}
+
+type DimensionInitializer_AspectClamp_CustomParameters struct {
+	minAspect string
+	maxAspect string
+	gravity   string
+}
+
+func DimensionInitializer_AspectClamp_Init() DimensionInitializer_AspectClamp {
+	return DimensionInitializer_AspectClamp{new(DimensionInitializer_AspectClamp_CustomParameters)}
+}
+
+// A 'DimensionInitializer' that crops any image whose aspect ratio lies outside a given range
+// (such as a very wide panorama) to bring it back within that range.
+type DimensionInitializer_AspectClamp struct {
+	p *DimensionInitializer_AspectClamp_CustomParameters
+}
+
+func (dia DimensionInitializer_AspectClamp) RegisterCustomParameters(parameters *Parameters) bool {
+	flag.StringVar(&(dia.p.minAspect), "min-aspect", "", "(Aspect clamp) Crop images narrower than this aspect ratio, e.g. '1:3'")
+	flag.StringVar(&(dia.p.maxAspect), "max-aspect", "", "(Aspect clamp) Crop images wider than this aspect ratio, e.g. '3:1'")
+	flag.StringVar(&(dia.p.gravity), "clamp-gravity", "center", "(Aspect clamp) Where to place the crop window: 'center', 'north', 'southeast', etc.")
+	return true
+}
+
+func (dia DimensionInitializer_AspectClamp) ParseCustomParameters(parameters *Parameters) bool {
+	minAspect, err := ParseAspectRatio(dia.p.minAspect)
+	if err != nil {
+		parameters.ProgressMonitor().ReportMessage(err.Error())
+		return false
+	}
+	maxAspect, err := ParseAspectRatio(dia.p.maxAspect)
+	if err != nil {
+		parameters.ProgressMonitor().ReportMessage(err.Error())
+		return false
+	}
+	if minAspect > 0 && maxAspect > 0 && minAspect > maxAspect {
+		parameters.ProgressMonitor().ReportMessage("-min-aspect must not exceed -max-aspect")
+		return false
+	}
+	gravity, err := ParseGravity(dia.p.gravity)
+	if err != nil {
+		parameters.ProgressMonitor().ReportMessage(err.Error())
+		return false
+	}
+	parameters.SetOther(AspectClamp_MinAspect, minAspect)
+	parameters.SetOther(AspectClamp_MaxAspect, maxAspect)
+	parameters.SetOther(AspectClamp_Gravity, gravity)
+	return true
+}
+
+func (dia DimensionInitializer_AspectClamp) InitializeDimensions(imageLayout ImageLayout) (il ImageLayout, err error) {
+	parameters := imageLayout.Parameters()
+	minAspect := parameters.OtherFloat(AspectClamp_MinAspect)
+	maxAspect := parameters.OtherFloat(AspectClamp_MaxAspect)
+	gravity := gravities["center"]
+	if gravityI, valid := parameters.Other(AspectClamp_Gravity); valid {
+		switch gravityI := gravityI.(type) {
+		case Gravity:
+			gravity = gravityI
+		default:
+		}
+	}
+	for _, img := range imageLayout.Images(false) {
+		info := imageLayout.ImageInfoOf(img)
+		fullSize := info.DimensionsOf()
+		windowSize := clampedWindowSize(fullSize, minAspect, maxAspect)
+		imageLayout.SetScaling(img, EmptyGeometry())
+		if windowSize == fullSize {
+			imageLayout.SetCropping(img, EmptyGeometry())
+			continue
+		}
+		parameters.ProgressMonitor().ReportDims("Clamped aspect ratio of "+info.FileName()+" by cropping to", windowSize)
+		imageLayout.SetCropping(img, NewCroppingGeometry(windowSize, gravity.Offset(fullSize, windowSize)))
+	}
+	il, err = imageLayout, nil
+	return
+}
@@ -0,0 +1,292 @@
+package CollageCreator
+
+import (
+	"errors"
+	"flag"
+	"image"
+	"image/draw"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/nfnt/resize"
+)
+
+const (
+	Chain_Steps string = "Chain_Steps"
+)
+
+// Gets the 'DimensionInitializer's provided by this library, keyed by the names by which
+// '-chain' refers to them.
+func StandardDimensionInitializers() map[string]DimensionInitializer {
+	return map[string]DimensionInitializer{
+		"original":     DimensionInitializer_Original{},
+		"uniform":      DimensionInitializer_Uniform_Init(),
+		"smart-crop":   DimensionInitializer_SmartCrop_Init(),
+		"focal-point":  DimensionInitializer_FocalPoint_Init(),
+		"rules":        DimensionInitializer_Rules_Init(),
+		"weighted":     DimensionInitializer_Weighted_Init(),
+		"aspect-clamp": DimensionInitializer_AspectClamp_Init(),
+	}
+}
+
+// An ImageInfo implementation that presents another image as it appears after
+// being scaled and cropped.
+type imageInfo_transformed struct {
+	inner    ImageInfo
+	scaling  Geometry
+	cropping Geometry
+}
+
+func (iit imageInfo_transformed) ImageId() ImageIdentifier {
+	return iit.inner.ImageId()
+}
+
+func (iit imageInfo_transformed) FileName() string {
+	return iit.inner.FileName()
+}
+
+func (iit imageInfo_transformed) Unwrap() ImageInfo {
+	return iit.inner
+}
+
+func (iit imageInfo_transformed) DimensionsOf() Dims {
+	return ScaleAndCrop(iit.inner.DimensionsOf(), iit.cropping, iit.scaling)
+}
+
+func (iit imageInfo_transformed) ImageData() interface{} {
+	data := iit.inner.ImageData()
+	imgData, ok := data.(image.Image)
+	if !ok {
+		return data
+	}
+	dimensions := iit.inner.DimensionsOf()
+	if iit.scaling.HasSize() {
+		dimensions = iit.scaling.Scale(dimensions)
+		imgData = resize.Resize(uint(toIntP(dimensions.X())), uint(toIntP(dimensions.Y())), imgData, resize.Bilinear)
+	}
+	if iit.cropping.HasOffset() {
+		offset := iit.cropping.Offset(dimensions)
+		dimensions = iit.cropping.Crop(dimensions)
+		cropped := image.NewNRGBA(image.Rect(0, 0, toIntP(dimensions.X()), toIntP(dimensions.Y())))
+		draw.Draw(cropped, cropped.Bounds(), imgData, imgData.Bounds().Min.Add(image.Point{toIntP(offset.X()), toIntP(offset.Y())}), draw.Src)
+		imgData = cropped
+	}
+	return imgData
+}
+
+// Gets the source image of an image as presented to a step of a chain, along with the scaling
+// and cropping that the steps before have applied to it. An image presented as it is in its
+// source is its own source, with empty geometries. Metadata given in the coordinates of the
+// source image, such as a focal point read from a sidecar file, must be translated by these.
+func SourceImageOf(info ImageInfo) (source ImageInfo, scaling, cropping Geometry) {
+	if transformed, ok := info.(imageInfo_transformed); ok {
+		return transformed.inner, transformed.scaling, transformed.cropping
+	}
+	return info, EmptyGeometry(), EmptyGeometry()
+}
+
+// How an image as presented to a step of a chain relates to its source image: a point 'p' of
+// the source lies at 'p*factor - offset' in the presented image, which is 'size' large.
+type sourceTransform struct {
+	source ImageInfo
+	factor Dims
+	offset Dims
+	size   Dims
+}
+
+func sourceTransformOf(info ImageInfo) sourceTransform {
+	source, scaling, cropping := SourceImageOf(info)
+	sourceSize := source.DimensionsOf()
+	scaled := scaling.Scale(sourceSize)
+	offset := NewDims(0, 0)
+	if cropping.HasOffset() {
+		offset = cropping.Offset(scaled)
+	}
+	return sourceTransform{source, NewDims(scaled.X()/sourceSize.X(), scaled.Y()/sourceSize.Y()), offset, info.DimensionsOf()}
+}
+
+// Translates a point of the source image into the presented image.
+func (st sourceTransform) point(p Dims) Dims {
+	return NewDims(p.X()*st.factor.X()-st.offset.X(), p.Y()*st.factor.Y()-st.offset.Y())
+}
+
+// Translates a rectangle of the source image into the presented image, clipped to it. Returns
+// false if nothing of the rectangle remains.
+func (st sourceTransform) rectangle(offset, size Dims) (Dims, Dims, bool) {
+	topLeft := st.point(offset)
+	bottomRight := st.point(NewDims(offset.X()+size.X(), offset.Y()+size.Y()))
+	topLeft = NewDims(math.Max(0, topLeft.X()), math.Max(0, topLeft.Y()))
+	bottomRight = NewDims(math.Min(st.size.X(), bottomRight.X()), math.Min(st.size.Y(), bottomRight.Y()))
+	if bottomRight.X() <= topLeft.X() || bottomRight.Y() <= topLeft.Y() {
+		return NewDims(0, 0), st.size, false
+	}
+	return topLeft, NewDims(bottomRight.X()-topLeft.X(), bottomRight.Y()-topLeft.Y()), true
+}
+
+// Translates scaling and cropping meant for the source image into scaling and cropping of the
+// presented image that, applied after the steps before, have the same effect as far as what
+// those steps have cropped away allows.
+func (st sourceTransform) geometries(scaling, cropping Geometry) (Geometry, Geometry) {
+	sourceSize := st.source.DimensionsOf()
+	scaled := scaling.Scale(sourceSize)
+	// The presented image scaled as 'scaling' scales the source, and how the result relates to
+	// the scaled source.
+	k := NewDims(scaled.X()/sourceSize.X()/st.factor.X(), scaled.Y()/sourceSize.Y()/st.factor.Y())
+	inScaled := sourceTransform{st.source, NewDims(1, 1), NewDims(st.offset.X()*k.X(), st.offset.Y()*k.Y()),
+		NewDims(st.size.X()*k.X(), st.size.Y()*k.Y())}
+	newScaling, newCropping := EmptyGeometry(), EmptyGeometry()
+	if inScaled.size != st.size {
+		newScaling = NewScalingGeometry(inScaled.size)
+	}
+	if cropping.HasOffset() {
+		offset, size, _ := inScaled.rectangle(cropping.Offset(scaled), cropping.Crop(scaled))
+		newCropping = NewCroppingGeometry(size, offset)
+	}
+	return newScaling, newCropping
+}
+
+// Creates a layout in which each image of 'imageLayout' appears, unscaled and uncropped,
+// as it would appear after the scaling and cropping currently set for it.
+func transformedImageLayout(imageLayout ImageLayout) ImageLayout {
+	rv := ImageLayout_impl{data: new(imageLayout_data)}
+	rv.data.size = imageLayout.CanvasSize()
+	rv.data.parameters = imageLayout.Parameters()
+	rv.data.images = imageLayout.Images(false)
+	rv.data.imageInfo = make(map[ImageIdentifier]ImageInfo)
+	rv.data.dimensions = make(map[ImageIdentifier]Dims)
+	rv.data.cropping = make(map[ImageIdentifier]Geometry)
+	rv.data.scaling = make(map[ImageIdentifier]Geometry)
+	rv.data.positions = make(map[ImageIdentifier]Dims)
+	rv.data.weights = make(map[ImageIdentifier]float64)
+	for _, img := range rv.data.images {
+		rv.data.imageInfo[img] = imageInfo_transformed{imageLayout.ImageInfoOf(img), imageLayout.ScalingOf(img), imageLayout.CroppingOf(img)}
+		rv.data.dimensions[img] = imageLayout.DimensionsOf(img)
+		rv.data.cropping[img] = EmptyGeometry()
+		rv.data.scaling[img] = EmptyGeometry()
+		rv.data.weights[img] = imageLayout.WeightOf(img)
+	}
+	return rv
+}
+
+// Sets the cropping and scaling of each image to those given it by an input manifest, or clears
+// them if it has none.
+func manifestDimensions(imageLayout ImageLayout) ImageLayout {
+	for _, img := range imageLayout.Images(false) {
+		annotations, _ := AnnotationsOf(imageLayout.ImageInfoOf(img))
+		imageLayout.SetCropping(img, annotations.Cropping)
+		imageLayout.SetScaling(img, annotations.Scaling)
+	}
+	return imageLayout
+}
+
+// Runs a list of 'DimensionInitializer's in order over a layout, each seeing the images as
+// scaled and cropped by the ones before it. The first sees them as cropped and scaled by
+// the input manifest, if any.
+func RunDimensionInitializerChain(imageLayout ImageLayout, steps []DimensionInitializer) (il ImageLayout, err error) {
+	imageLayout = manifestDimensions(imageLayout)
+	for _, step := range steps {
+		var stepLayout ImageLayout
+		stepLayout, err = step.InitializeDimensions(transformedImageLayout(imageLayout))
+		if err != nil {
+			il = imageLayout
+			return
+		}
+		for _, img := range imageLayout.Images(false) {
+			scaling, cropping := ComposeScaleAndCrop(imageLayout.ImageInfoOf(img).DimensionsOf(),
+				imageLayout.ScalingOf(img), imageLayout.CroppingOf(img),
+				stepLayout.ScalingOf(img), stepLayout.CroppingOf(img))
+			imageLayout.SetCropping(img, cropping)
+			imageLayout.SetScaling(img, scaling)
+			imageLayout = imageLayout.SetWeight(img, stepLayout.WeightOf(img))
+		}
+	}
+	il, err = imageLayout, nil
+	return
+}
+
+type DimensionInitializer_Chain_CustomParameters struct {
+	chain string
+}
+
+func DimensionInitializer_Chain_Init() DimensionInitializer_Chain {
+	return DimensionInitializer_Chain_InitWith(StandardDimensionInitializers())
+}
+
+// Creates a chain that may be composed of any of the given 'DimensionInitializer's,
+// keyed by the names by which '-chain' refers to them.
+func DimensionInitializer_Chain_InitWith(available map[string]DimensionInitializer) DimensionInitializer_Chain {
+	return DimensionInitializer_Chain{new(DimensionInitializer_Chain_CustomParameters), available}
+}
+
+// A 'DimensionInitializer' that runs a list of other 'DimensionInitializer's in order, each
+// seeing the cropping and scaling set by the ones before it, and composes their results.
+// As it registers the custom parameters of every initializer it may run, none of them should
+// be registered separately alongside it.
+type DimensionInitializer_Chain struct {
+	p         *DimensionInitializer_Chain_CustomParameters
+	available map[string]DimensionInitializer
+}
+
+func (dic DimensionInitializer_Chain) availableNames() []string {
+	names := make([]string, 0, len(dic.available))
+	for name := range dic.available {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (dic DimensionInitializer_Chain) RegisterCustomParameters(parameters *Parameters) bool {
+	flag.StringVar(&(dic.p.chain), "chain", "", "(Chain) Comma-separated list of preprocessing steps to run in order, from: "+strings.Join(dic.availableNames(), ", "))
+	for _, name := range dic.availableNames() {
+		if !dic.available[name].RegisterCustomParameters(parameters) {
+			return false
+		}
+	}
+	return true
+}
+
+func (dic DimensionInitializer_Chain) ParseCustomParameters(parameters *Parameters) bool {
+	steps := []DimensionInitializer{}
+	parsed := map[string]bool{}
+	for _, name := range strings.Split(dic.p.chain, ",") {
+		name = strings.TrimSpace(strings.ToLower(name))
+		if name == "" {
+			continue
+		}
+		step, valid := dic.available[name]
+		if !valid {
+			parameters.ProgressMonitor().ReportMessage("Unknown -chain step '" + name + "'; must be one of: " + strings.Join(dic.availableNames(), ", "))
+			return false
+		}
+		if !parsed[name] {
+			if !step.ParseCustomParameters(parameters) {
+				return false
+			}
+			parsed[name] = true
+		}
+		steps = append(steps, step)
+	}
+	if len(steps) == 0 {
+		parameters.ProgressMonitor().ReportMessage("-chain must name at least one step")
+		return false
+	}
+	parameters.SetOther(Chain_Steps, steps)
+	return true
+}
+
+func (dic DimensionInitializer_Chain) InitializeDimensions(imageLayout ImageLayout) (il ImageLayout, err error) {
+	stepsI, valid := imageLayout.Parameters().Other(Chain_Steps)
+	if !valid {
+		il, err = imageLayout, errors.New("no steps given for the dimension initializer chain")
+		return
+	}
+	switch steps := stepsI.(type) {
+	case []DimensionInitializer:
+		il, err = RunDimensionInitializerChain(imageLayout, steps)
+	default:
+		il, err = imageLayout, errors.New("mistyped steps for the dimension initializer chain")
+	}
+	return
+}
@@ -0,0 +1,134 @@
+package CollageCreator
+
+import (
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestComposeScaleAndCrop(t *testing.T) {
+	empty := EmptyGeometry()
+	cases := []struct {
+		name                                     string
+		original                                 Dims
+		scaling1, cropping1, scaling2, cropping2 Geometry
+		// The scaled size, crop offset, and crop size of the composition.
+		scaled, offset, cropped Dims
+		// Whether the composition should leave the image unscaled or uncropped.
+		noScaling, noCropping bool
+	}{
+		{"nothing", NewDims(400, 300), empty, empty, empty, empty,
+			NewDims(400, 300), NewDims(0, 0), NewDims(400, 300), true, true},
+		{"scale then crop", NewDims(400, 300), MustParseGeometry("50%"), empty, empty, MustParseGeometry("100x100+10+20"),
+			NewDims(200, 150), NewDims(10, 20), NewDims(100, 100), false, false},
+		{"crop then scale", NewDims(400, 300), empty, MustParseGeometry("200x300+100+0"), MustParseGeometry("50%"), empty,
+			NewDims(200, 150), NewDims(50, 0), NewDims(100, 150), false, false},
+		{"scaling cancelled out", NewDims(400, 300), MustParseGeometry("50%"), MustParseGeometry("100x100+50+25"),
+			MustParseGeometry("200%"), MustParseGeometry("100x100+50+50"),
+			NewDims(400, 300), NewDims(150, 100), NewDims(100, 100), true, false},
+		{"percent crop then exact scale", NewDims(400, 200), empty, MustParseGeometry("50x100+0+0%"),
+			MustParseGeometry("100x100!"), empty,
+			NewDims(200, 100), NewDims(0, 0), NewDims(100, 100), false, false},
+	}
+	for _, c := range cases {
+		scaling, cropping := ComposeScaleAndCrop(c.original, c.scaling1, c.cropping1, c.scaling2, c.cropping2)
+		if scaling.HasSize() == c.noScaling || cropping.HasOffset() == c.noCropping {
+			t.Errorf("%s: composed to scaling '%s' and cropping '%s'", c.name, scaling, cropping)
+			continue
+		}
+		scaled := scaling.Scale(c.original)
+		if scaled != c.scaled {
+			t.Errorf("%s: scaled to %v, want %v", c.name, scaled, c.scaled)
+		}
+		if !c.noCropping {
+			if offset := cropping.Offset(scaled); offset != c.offset {
+				t.Errorf("%s: cropped at %v, want %v", c.name, offset, c.offset)
+			}
+		}
+		sequential := ScaleAndCrop(ScaleAndCrop(c.original, c.cropping1, c.scaling1), c.cropping2, c.scaling2)
+		if composed := ScaleAndCrop(c.original, cropping, scaling); composed != c.cropped || composed != sequential {
+			t.Errorf("%s: composition gives %v, steps in turn %v, want %v", c.name, composed, sequential, c.cropped)
+		}
+	}
+}
+
+// A 'DimensionInitializer' that gives every image the same scaling and cropping.
+type fixedDimensionInitializer struct {
+	scaling, cropping Geometry
+}
+
+func (fdi fixedDimensionInitializer) RegisterCustomParameters(parameters *Parameters) bool {
+	return true
+}
+
+func (fdi fixedDimensionInitializer) ParseCustomParameters(parameters *Parameters) bool {
+	return true
+}
+
+func (fdi fixedDimensionInitializer) InitializeDimensions(imageLayout ImageLayout) (ImageLayout, error) {
+	for _, img := range imageLayout.Images(false) {
+		imageLayout.SetScaling(img, fdi.scaling)
+		imageLayout.SetCropping(img, fdi.cropping)
+	}
+	return imageLayout, nil
+}
+
+// Creates a layout of one blank 400x100 image whose name is a path in a temporary directory,
+// with the given sidecar file next to it.
+func newSidecarTestLayout(t *testing.T, parameters *Parameters, sidecarExt, sidecar string) ImageLayout {
+	name := filepath.Join(t.TempDir(), "photo.png")
+	if err := os.WriteFile(name+sidecarExt, []byte(sidecar), 0644); err != nil {
+		t.Fatal(err)
+	}
+	infos := newTestLayout(parameters, NewDims(400, 100))
+	img := infos.Images(false)[0]
+	return createImageLayout(parameters, []ImageInfo{NewImageInfoFromImage(img, name, infos.ImageInfoOf(img).ImageData().(image.Image))})
+}
+
+// Checks that a chain left the single image of 'imageLayout' cropped as 'want' crops the
+// unscaled source image.
+func assertChainCrop(t *testing.T, imageLayout ImageLayout, want string) {
+	t.Helper()
+	img := imageLayout.Images(false)[0]
+	original := NewDims(400, 100)
+	scaled := imageLayout.ScalingOf(img).Scale(original)
+	factor := original.X() / scaled.X()
+	offset, size := imageLayout.CroppingOf(img).Offset(scaled), imageLayout.CroppingOf(img).Crop(scaled)
+	wantGeometry := MustParseGeometry(want)
+	wantOffset, wantSize := wantGeometry.Offset(original), wantGeometry.Crop(original)
+	assertNear(t, "crop x in the source", offset.X()*factor, wantOffset.X(), 1)
+	assertNear(t, "crop y in the source", offset.Y()*factor, wantOffset.Y(), 1)
+	assertNear(t, "crop width in the source", size.X()*factor, wantSize.X(), 1)
+	assertNear(t, "crop height in the source", size.Y()*factor, wantSize.Y(), 1)
+}
+
+// The first step keeps the right half of the image at half size.
+var rightHalfAtHalfSize = fixedDimensionInitializer{MustParseGeometry("50%"), MustParseGeometry("100x50+100+0")}
+
+func TestChainTranslatesFocalPoint(t *testing.T) {
+	parameters := newTestParameters()
+	parameters.SetOther(FocalPoint_Geometry, MustParseGeometry("1x1"))
+	parameters.SetOther(FocalPoint_Exact, false)
+	imageLayout := newSidecarTestLayout(t, parameters, ".json", `{"focalPoint": {"x": 0.75, "y": 0.5}}`)
+	imageLayout, err := RunDimensionInitializerChain(imageLayout, []DimensionInitializer{rightHalfAtHalfSize, DimensionInitializer_FocalPoint_Init()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The focal point lies 300 pixels across the source; the square around it stays within
+	// the right half.
+	assertChainCrop(t, imageLayout, "100x100+250+0")
+}
+
+func TestChainTranslatesRulesSidecar(t *testing.T) {
+	parameters := newTestParameters()
+	imageLayout := newSidecarTestLayout(t, parameters, ".collage.json", `{"crop": "100x100+250+0"}`)
+	imageLayout, err := RunDimensionInitializerChain(imageLayout, []DimensionInitializer{rightHalfAtHalfSize, DimensionInitializer_Rules_Init()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertChainCrop(t, imageLayout, "100x100+250+0")
+	if dims := imageLayout.DimensionsOf(imageLayout.Images(false)[0]); dims != NewDims(100, 100) {
+		t.Errorf("sidecar crop gave %v, want 100x100 at the source's scale", dims)
+	}
+}
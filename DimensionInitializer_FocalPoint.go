@@ -0,0 +1,200 @@
+package CollageCreator
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+)
+
+const (
+	FocalPoint_Geometry string = "FocalPoint_Geometry"
+	FocalPoint_Exact    string = "FocalPoint_Exact"
+)
+
+// The layout of a JSON sidecar file holding focal-point metadata. The focal point is
+// given as fractions of the image's width and height; the crop rectangle, in pixels.
+type focalPoint_Sidecar struct {
+	FocalPoint *struct {
+		X float64 `json:"x"`
+		Y float64 `json:"y"`
+	} `json:"focalPoint"`
+	Crop *struct {
+		X      float64 `json:"x"`
+		Y      float64 `json:"y"`
+		Width  float64 `json:"width"`
+		Height float64 `json:"height"`
+	} `json:"crop"`
+}
+
+// Focal-point metadata for one image, in pixels. 'hasFocalPoint' is false if no focal point
+// was found, in which case 'focalPoint' is the centre of the crop rectangle.
+type focalPoint_Metadata struct {
+	hasFocalPoint bool
+	focalPoint    Dims
+	cropOffset    Dims
+	cropSize      Dims
+}
+
+func readFocalPointJSON(fileName string, fullSize Dims, md *focalPoint_Metadata) bool {
+	sidecar := findSidecar(fileName, ".json")
+	if sidecar == "" {
+		return false
+	}
+	contents, err := os.ReadFile(sidecar)
+	if err != nil {
+		return false
+	}
+	var parsed focalPoint_Sidecar
+	if json.Unmarshal(contents, &parsed) != nil {
+		return false
+	}
+	if parsed.Crop != nil {
+		md.cropOffset = NewDims(parsed.Crop.X, parsed.Crop.Y)
+		md.cropSize = NewDims(parsed.Crop.Width, parsed.Crop.Height)
+	}
+	if parsed.FocalPoint != nil {
+		md.hasFocalPoint = true
+		md.focalPoint = NewDims(parsed.FocalPoint.X*fullSize.X(), parsed.FocalPoint.Y*fullSize.Y())
+	}
+	return parsed.Crop != nil || parsed.FocalPoint != nil
+}
+
+// Reads focal-point metadata from XMP. The focal point is taken from properties named
+// 'FocalPointX' and 'FocalPointY' in any namespace, and the crop rectangle from Camera
+// Raw's 'CropLeft', 'CropTop', 'CropRight', and 'CropBottom'; all are fractions of the
+// image's width or height.
+func readFocalPointXMP(fileName string, fullSize Dims, md *focalPoint_Metadata) bool {
+	packet, found := readXMPPacket(fileName)
+	if !found {
+		return false
+	}
+	props := xmpProperties(packet)
+	fraction := func(name string) (float64, bool) {
+		value, in := props[name]
+		if !in {
+			return 0, false
+		}
+		f, err := strconv.ParseFloat(value, 64)
+		return f, err == nil
+	}
+	rv := false
+	left, okL := fraction("CropLeft")
+	top, okT := fraction("CropTop")
+	right, okR := fraction("CropRight")
+	bottom, okB := fraction("CropBottom")
+	if okL && okT && okR && okB && props["HasCrop"] != "False" && right > left && bottom > top {
+		md.cropOffset = NewDims(left*fullSize.X(), top*fullSize.Y())
+		md.cropSize = NewDims((right-left)*fullSize.X(), (bottom-top)*fullSize.Y())
+		rv = true
+	}
+	x, okX := fraction("FocalPointX")
+	y, okY := fraction("FocalPointY")
+	if okX && okY {
+		md.hasFocalPoint = true
+		md.focalPoint = NewDims(x*fullSize.X(), y*fullSize.Y())
+		rv = true
+	}
+	return rv
+}
+
+// Reads the focal-point metadata for an image from a JSON sidecar, an XMP sidecar,
+// or an embedded XMP packet, in that order of preference.
+func readFocalPointMetadata(fileName string, fullSize Dims) focalPoint_Metadata {
+	md := focalPoint_Metadata{cropOffset: NewDims(0, 0), cropSize: fullSize}
+	if !readFocalPointJSON(fileName, fullSize, &md) {
+		readFocalPointXMP(fileName, fullSize, &md)
+	}
+	md.cropOffset = NewDims(
+		math.Max(0, math.Min(md.cropOffset.X(), fullSize.X()-1)),
+		math.Max(0, math.Min(md.cropOffset.Y(), fullSize.Y()-1)))
+	md.cropSize = NewDims(
+		math.Max(1, math.Min(md.cropSize.X(), fullSize.X()-md.cropOffset.X())),
+		math.Max(1, math.Min(md.cropSize.Y(), fullSize.Y()-md.cropOffset.Y())))
+	if !md.hasFocalPoint {
+		md.focalPoint = NewDims(md.cropOffset.X()+md.cropSize.X()/2, md.cropOffset.Y()+md.cropSize.Y()/2)
+	}
+	return md
+}
+
+// Places a window of the given size within the given bounds so that 'centre' lies as near
+// the middle of the window as the bounds allow. A centre outside the bounds, as when a focal
+// point lies outside the crop rectangle given with it, is first brought to their nearest edge.
+func placeWindow(centre, windowSize, boundsOffset, boundsSize Dims) Dims {
+	centre = NewDims(
+		math.Max(boundsOffset.X(), math.Min(boundsOffset.X()+boundsSize.X(), centre.X())),
+		math.Max(boundsOffset.Y(), math.Min(boundsOffset.Y()+boundsSize.Y(), centre.Y())))
+	return NewDims(
+		math.Round(math.Max(boundsOffset.X(), math.Min(boundsOffset.X()+boundsSize.X()-windowSize.X(), centre.X()-windowSize.X()/2))),
+		math.Round(math.Max(boundsOffset.Y(), math.Min(boundsOffset.Y()+boundsSize.Y()-windowSize.Y(), centre.Y()-windowSize.Y()/2))))
+}
+
+type DimensionInitializer_FocalPoint_CustomParameters struct {
+	geometry string
+	exact    bool
+}
+
+func DimensionInitializer_FocalPoint_Init() DimensionInitializer_FocalPoint {
+	return DimensionInitializer_FocalPoint{new(DimensionInitializer_FocalPoint_CustomParameters)}
+}
+
+// A 'DimensionInitializer' that crops each image around a focal point read from a JSON or XMP
+// sidecar file or an embedded XMP packet, keeping the focal point as near the centre of the
+// crop as possible. A crop rectangle in the metadata, if any, bounds the crop; images without
+// a focal point are cropped about their centre.
+type DimensionInitializer_FocalPoint struct {
+	p *DimensionInitializer_FocalPoint_CustomParameters
+}
+
+func (dif DimensionInitializer_FocalPoint) RegisterCustomParameters(parameters *Parameters) bool {
+	flag.StringVar(&(dif.p.geometry), "focal-crop", "", "(Focal point) Crop each image to the largest window of this aspect ratio around its focal point")
+	flag.BoolVar(&(dif.p.exact), "focal-crop-exact", false, "(Focal point) Treat the -focal-crop geometry as the exact size of the window rather than an aspect ratio")
+	return true
+}
+
+func (dif DimensionInitializer_FocalPoint) ParseCustomParameters(parameters *Parameters) bool {
+	if dif.p.geometry == "" {
+		parameters.SetOther(FocalPoint_Geometry, EmptyGeometry())
+	} else {
+		geometry, err := ParseGeometry(dif.p.geometry)
+		if err != nil {
+			parameters.ProgressMonitor().ReportMessage(err.Error())
+			return false
+		}
+		if !geometry.HasWidth() || !geometry.HasHeight() {
+			parameters.ProgressMonitor().ReportMessage("-focal-crop geometry must specify both a width and a height")
+			return false
+		}
+		parameters.SetOther(FocalPoint_Geometry, geometry)
+	}
+	parameters.SetOther(FocalPoint_Exact, dif.p.exact)
+	return true
+}
+
+func (dif DimensionInitializer_FocalPoint) InitializeDimensions(imageLayout ImageLayout) (il ImageLayout, err error) {
+	geometry := imageLayout.Parameters().OtherGeometry(FocalPoint_Geometry)
+	exact := imageLayout.Parameters().OtherBool(FocalPoint_Exact)
+	for _, img := range imageLayout.Images(false) {
+		info := imageLayout.ImageInfoOf(img)
+		// The metadata describes the source image, which steps of a chain before this one
+		// may have scaled and cropped.
+		transform := sourceTransformOf(info)
+		md := readFocalPointMetadata(info.FileName(), transform.source.DimensionsOf())
+		md.focalPoint = transform.point(md.focalPoint)
+		md.cropOffset, md.cropSize, _ = transform.rectangle(md.cropOffset, md.cropSize)
+		if !md.hasFocalPoint {
+			imageLayout.Parameters().ProgressMonitor().ReportMessage(fmt.Sprintf("No focal point for %s; cropping about the centre", info.FileName()))
+		}
+		windowSize := md.cropSize
+		if geometry.HasSize() {
+			windowSize = cropWindowSize(md.cropSize, geometry, exact)
+		}
+		offset := placeWindow(md.focalPoint, windowSize, md.cropOffset, md.cropSize)
+		imageLayout.SetScaling(img, EmptyGeometry())
+		imageLayout.SetCropping(img, NewCroppingGeometry(windowSize, offset))
+	}
+	il, err = imageLayout, nil
+	return
+}
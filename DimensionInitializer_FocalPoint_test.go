@@ -0,0 +1,46 @@
+package CollageCreator
+
+import (
+	"testing"
+)
+
+func TestPlaceWindow(t *testing.T) {
+	boundsOffset, boundsSize := NewDims(100, 0), NewDims(200, 100)
+	cases := []struct {
+		centre, windowSize, want Dims
+	}{
+		{NewDims(200, 50), NewDims(100, 100), NewDims(150, 0)},
+		{NewDims(120, 50), NewDims(100, 100), NewDims(100, 0)},
+		// Focal points outside the bounds.
+		{NewDims(20, 50), NewDims(100, 100), NewDims(100, 0)},
+		{NewDims(390, -40), NewDims(100, 50), NewDims(200, 0)},
+		{NewDims(250, 180), NewDims(100, 50), NewDims(200, 50)},
+	}
+	for _, c := range cases {
+		got := placeWindow(c.centre, c.windowSize, boundsOffset, boundsSize)
+		if got != c.want {
+			t.Errorf("window of %v about %v placed at %v, want %v", c.windowSize, c.centre, got, c.want)
+		}
+		if got.X() < boundsOffset.X() || got.Y() < boundsOffset.Y() ||
+			got.X()+c.windowSize.X() > boundsOffset.X()+boundsSize.X() || got.Y()+c.windowSize.Y() > boundsOffset.Y()+boundsSize.Y() {
+			t.Errorf("window of %v about %v placed at %v, outside the bounds", c.windowSize, c.centre, got)
+		}
+	}
+}
+
+func TestFocalPointOutsideCrop(t *testing.T) {
+	parameters := newTestParameters()
+	parameters.SetOther(FocalPoint_Geometry, MustParseGeometry("1x1"))
+	parameters.SetOther(FocalPoint_Exact, false)
+	imageLayout := newSidecarTestLayout(t, parameters, ".json",
+		`{"focalPoint": {"x": 0.95, "y": 0.5}, "crop": {"x": 0, "y": 0, "width": 250, "height": 100}}`)
+	imageLayout, err := DimensionInitializer_FocalPoint_Init().InitializeDimensions(imageLayout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := imageLayout.Images(false)[0]
+	cropping := imageLayout.CroppingOf(img)
+	if offset, size := cropping.Offset(NewDims(400, 100)), cropping.Crop(NewDims(400, 100)); offset != NewDims(150, 0) || size != NewDims(100, 100) {
+		t.Errorf("cropped to %v at %v, want 100x100 at the right edge of the crop rectangle", size, offset)
+	}
+}
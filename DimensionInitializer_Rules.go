@@ -0,0 +1,232 @@
+package CollageCreator
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	Rules_Rules string = "Rules_Rules"
+)
+
+// The tolerance within which an image's aspect ratio must be 1 for it to count as square.
+const rules_SquareTolerance float64 = 0.01
+
+// The layout of one rule in a rules file, and of a per-image sidecar override
+// (for which only 'crop' and 'scale' are used).
+type rules_RuleSpec struct {
+	Name        string `json:"name"`
+	Glob        string `json:"glob"`
+	Orientation string `json:"orientation"`
+	MinSize     string `json:"minSize"`
+	MaxSize     string `json:"maxSize"`
+	Crop        string `json:"crop"`
+	Scale       string `json:"scale"`
+}
+
+// A parsed rule assigning cropping and scaling to the images it matches.
+// Zero dimensions in 'minSize' and 'maxSize' signify no limit.
+type DimensionInitializer_Rules_Rule struct {
+	name        string
+	glob        string
+	orientation string
+	minSize     Dims
+	maxSize     Dims
+	cropping    Geometry
+	scaling     Geometry
+}
+
+func parseGeometryOrEmpty(arg string) (Geometry, error) {
+	if arg == "" {
+		return EmptyGeometry(), nil
+	}
+	return ParseGeometry(arg)
+}
+
+func parseRule(spec rules_RuleSpec, index int) (rule DimensionInitializer_Rules_Rule, err error) {
+	rule = DimensionInitializer_Rules_Rule{name: spec.Name, glob: spec.Glob, minSize: NewDims(0, 0), maxSize: NewDims(0, 0)}
+	if rule.name == "" {
+		rule.name = fmt.Sprintf("#%d", index+1)
+	}
+	rule.orientation = strings.ToLower(spec.Orientation)
+	switch rule.orientation {
+	case "", "portrait", "landscape", "square":
+	default:
+		err = errors.New("rule " + rule.name + ": orientation must be 'portrait', 'landscape', or 'square'")
+		return
+	}
+	if rule.glob != "" {
+		if _, err = filepath.Match(rule.glob, ""); err != nil {
+			return
+		}
+	}
+	if spec.MinSize != "" {
+		if rule.minSize, err = ParseDims(spec.MinSize); err != nil {
+			return
+		}
+	}
+	if spec.MaxSize != "" {
+		if rule.maxSize, err = ParseDims(spec.MaxSize); err != nil {
+			return
+		}
+	}
+	if rule.cropping, err = parseGeometryOrEmpty(spec.Crop); err != nil {
+		return
+	}
+	rule.scaling, err = parseGeometryOrEmpty(spec.Scale)
+	return
+}
+
+// Reads an ordered list of rules from a JSON file containing an array of rule objects.
+func ReadRulesFile(fileName string) (rules []DimensionInitializer_Rules_Rule, err error) {
+	contents, err := os.ReadFile(fileName)
+	if err != nil {
+		return
+	}
+	var specs []rules_RuleSpec
+	if err = json.Unmarshal(contents, &specs); err != nil {
+		return
+	}
+	rules = make([]DimensionInitializer_Rules_Rule, len(specs))
+	for i, spec := range specs {
+		if rules[i], err = parseRule(spec, i); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func imageOrientation(size Dims) string {
+	aspect := size.X() / size.Y()
+	if math.Abs(aspect-1) <= rules_SquareTolerance {
+		return "square"
+	} else if aspect > 1 {
+		return "landscape"
+	}
+	return "portrait"
+}
+
+// Tests whether a rule applies to the image with the given file name and dimensions.
+func (rule DimensionInitializer_Rules_Rule) Matches(fileName string, size Dims) bool {
+	if rule.glob != "" {
+		matchedBase, _ := filepath.Match(rule.glob, filepath.Base(fileName))
+		matchedFull, _ := filepath.Match(rule.glob, fileName)
+		if !matchedBase && !matchedFull {
+			return false
+		}
+	}
+	if rule.orientation != "" && rule.orientation != imageOrientation(size) {
+		return false
+	}
+	if (rule.minSize.X() > 0 && size.X() < rule.minSize.X()) || (rule.minSize.Y() > 0 && size.Y() < rule.minSize.Y()) {
+		return false
+	}
+	if (rule.maxSize.X() > 0 && size.X() > rule.maxSize.X()) || (rule.maxSize.Y() > 0 && size.Y() > rule.maxSize.Y()) {
+		return false
+	}
+	return true
+}
+
+// Reads a per-image override from a '.collage.json' sidecar file, if one exists.
+func readRulesOverride(fileName string) (rule DimensionInitializer_Rules_Rule, found bool, err error) {
+	sidecar := findSidecar(fileName, ".collage.json")
+	if sidecar == "" {
+		return
+	}
+	contents, err := os.ReadFile(sidecar)
+	if err != nil {
+		return
+	}
+	var spec rules_RuleSpec
+	if err = json.Unmarshal(contents, &spec); err != nil {
+		err = fmt.Errorf("%s: %w", sidecar, err)
+		return
+	}
+	spec.Name = "sidecar " + sidecar
+	rule, err = parseRule(spec, 0)
+	found = err == nil
+	return
+}
+
+type DimensionInitializer_Rules_CustomParameters struct {
+	rulesFile string
+}
+
+func DimensionInitializer_Rules_Init() DimensionInitializer_Rules {
+	return DimensionInitializer_Rules{new(DimensionInitializer_Rules_CustomParameters)}
+}
+
+// A 'DimensionInitializer' that applies to each image the cropping and scaling of the first rule,
+// from an ordered list, that matches it by file name, orientation, or size. A '.collage.json'
+// sidecar file next to an image overrides the rules for that image.
+type DimensionInitializer_Rules struct {
+	p *DimensionInitializer_Rules_CustomParameters
+}
+
+func (dir DimensionInitializer_Rules) RegisterCustomParameters(parameters *Parameters) bool {
+	flag.StringVar(&(dir.p.rulesFile), "rules", "", "(Rules) JSON file holding an ordered list of crop/scale rules")
+	return true
+}
+
+func (dir DimensionInitializer_Rules) ParseCustomParameters(parameters *Parameters) bool {
+	rules := []DimensionInitializer_Rules_Rule{}
+	if dir.p.rulesFile != "" {
+		var err error
+		rules, err = ReadRulesFile(dir.p.rulesFile)
+		if err != nil {
+			parameters.ProgressMonitor().ReportMessage(err.Error())
+			return false
+		}
+	}
+	parameters.SetOther(Rules_Rules, rules)
+	return true
+}
+
+func (dir DimensionInitializer_Rules) InitializeDimensions(imageLayout ImageLayout) (il ImageLayout, err error) {
+	rules := []DimensionInitializer_Rules_Rule{}
+	if rulesI, valid := imageLayout.Parameters().Other(Rules_Rules); valid {
+		switch rulesI := rulesI.(type) {
+		case []DimensionInitializer_Rules_Rule:
+			rules = rulesI
+		default:
+		}
+	}
+	progressMonitor := imageLayout.Parameters().ProgressMonitor()
+	for _, img := range imageLayout.Images(false) {
+		info := imageLayout.ImageInfoOf(img)
+		rule, found, errO := readRulesOverride(info.FileName())
+		if errO != nil {
+			il, err = imageLayout, errO
+			return
+		}
+		override := found
+		for i := 0; !found && i < len(rules); i++ {
+			if rules[i].Matches(info.FileName(), info.DimensionsOf()) {
+				rule, found = rules[i], true
+			}
+		}
+		if found {
+			progressMonitor.ReportMessage(fmt.Sprintf("%s: applying rule %s (crop '%s', scale '%s')", info.FileName(), rule.name, rule.cropping, rule.scaling))
+			scaling, cropping := rule.scaling, rule.cropping
+			if override {
+				// A sidecar describes the source image, which steps of a chain before this
+				// one may have scaled and cropped.
+				scaling, cropping = sourceTransformOf(info).geometries(scaling, cropping)
+			}
+			imageLayout.SetCropping(img, cropping)
+			imageLayout.SetScaling(img, scaling)
+		} else {
+			progressMonitor.ReportMessage(fmt.Sprintf("%s: no rule applies", info.FileName()))
+			imageLayout.SetCropping(img, EmptyGeometry())
+			imageLayout.SetScaling(img, EmptyGeometry())
+		}
+	}
+	il, err = imageLayout, nil
+	return
+}
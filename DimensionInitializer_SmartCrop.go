@@ -0,0 +1,222 @@
+package CollageCreator
+
+import (
+	"errors"
+	"flag"
+	"image"
+	"math"
+	"strings"
+
+	"github.com/nfnt/resize"
+)
+
+const (
+	SmartCrop_Geometry string = "SmartCrop_Geometry"
+	SmartCrop_Exact    string = "SmartCrop_Exact"
+	SmartCrop_Method   string = "SmartCrop_Method"
+)
+
+// The ways in which the smart-crop initializer can measure how interesting each part of an image is.
+type SmartCropMethod int
+
+const (
+	// Measures the local entropy of the luminance around each pixel.
+	SmartCropEntropy SmartCropMethod = iota
+	// Measures the strength of the luminance gradient at each pixel.
+	SmartCropEdges
+	// Measures the distance of each pixel's colour from the mean colour of the image.
+	SmartCropContrast
+)
+
+// The size, in pixels, of the longer side of the downscaled copy over which saliency is computed.
+const smartCropAnalysisSize uint = 64
+
+// The neighbourhood radius, in pixels of the downscaled copy, used by the entropy measure.
+const smartCropEntropyRadius int = 2
+
+// Computes a saliency map, in row-major order, of the given image.
+func saliencyMap(img image.Image, method SmartCropMethod) (saliency []float64, width, height int) {
+	small := resize.Thumbnail(smartCropAnalysisSize, smartCropAnalysisSize, img, resize.Bilinear)
+	bounds := small.Bounds()
+	width, height = bounds.Dx(), bounds.Dy()
+	luma := make([]float64, width*height)
+	rgb := make([][3]float64, width*height)
+	mean := [3]float64{0, 0, 0}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			r, g, b, _ := small.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			rgb[y*width+x] = [3]float64{float64(r) / 0xffff, float64(g) / 0xffff, float64(b) / 0xffff}
+			luma[y*width+x] = 0.299*rgb[y*width+x][0] + 0.587*rgb[y*width+x][1] + 0.114*rgb[y*width+x][2]
+			for c := 0; c < 3; c++ {
+				mean[c] += rgb[y*width+x][c]
+			}
+		}
+	}
+	for c := 0; c < 3; c++ {
+		mean[c] /= float64(width * height)
+	}
+	lumaAt := func(x, y int) float64 {
+		x = int(math.Max(0, math.Min(float64(x), float64(width-1))))
+		y = int(math.Max(0, math.Min(float64(y), float64(height-1))))
+		return luma[y*width+x]
+	}
+	saliency = make([]float64, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			switch method {
+			case SmartCropEdges:
+				gx := lumaAt(x+1, y-1) + 2*lumaAt(x+1, y) + lumaAt(x+1, y+1) - lumaAt(x-1, y-1) - 2*lumaAt(x-1, y) - lumaAt(x-1, y+1)
+				gy := lumaAt(x-1, y+1) + 2*lumaAt(x, y+1) + lumaAt(x+1, y+1) - lumaAt(x-1, y-1) - 2*lumaAt(x, y-1) - lumaAt(x+1, y-1)
+				saliency[y*width+x] = math.Hypot(gx, gy)
+			case SmartCropContrast:
+				px := rgb[y*width+x]
+				saliency[y*width+x] = math.Sqrt((px[0]-mean[0])*(px[0]-mean[0]) + (px[1]-mean[1])*(px[1]-mean[1]) + (px[2]-mean[2])*(px[2]-mean[2]))
+			default:
+				var histogram [16]float64
+				count := 0.0
+				for dy := -smartCropEntropyRadius; dy <= smartCropEntropyRadius; dy++ {
+					for dx := -smartCropEntropyRadius; dx <= smartCropEntropyRadius; dx++ {
+						histogram[int(math.Min(15, lumaAt(x+dx, y+dy)*16))]++
+						count++
+					}
+				}
+				entropy := 0.0
+				for _, n := range histogram {
+					if n > 0 {
+						entropy -= (n / count) * math.Log2(n/count)
+					}
+				}
+				saliency[y*width+x] = entropy
+			}
+		}
+	}
+	return
+}
+
+// Finds the offset, in the coordinates of the saliency map, of the window of the given size
+// with the greatest total saliency; ties are broken in favour of the most central window.
+func mostSalientWindow(saliency []float64, width, height, winWidth, winHeight int) (x, y int) {
+	summed := make([]float64, (width+1)*(height+1))
+	for j := 0; j < height; j++ {
+		for i := 0; i < width; i++ {
+			summed[(j+1)*(width+1)+i+1] = saliency[j*width+i] + summed[j*(width+1)+i+1] + summed[(j+1)*(width+1)+i] - summed[j*(width+1)+i]
+		}
+	}
+	bestScore, bestDistance := math.Inf(-1), math.Inf(1)
+	centreX, centreY := float64(width-winWidth)/2, float64(height-winHeight)/2
+	for j := 0; j+winHeight <= height; j++ {
+		for i := 0; i+winWidth <= width; i++ {
+			score := summed[(j+winHeight)*(width+1)+i+winWidth] - summed[j*(width+1)+i+winWidth] - summed[(j+winHeight)*(width+1)+i] + summed[j*(width+1)+i]
+			distance := math.Hypot(float64(i)-centreX, float64(j)-centreY)
+			if score > bestScore+1e-9 || (math.Abs(score-bestScore) <= 1e-9 && distance < bestDistance) {
+				bestScore, bestDistance = score, distance
+				x, y = i, j
+			}
+		}
+	}
+	return
+}
+
+// Calculates the size of the crop window to apply to an image of the given size: either the
+// largest window having the aspect ratio of 'geom' or, if 'exact' is set, the size given by 'geom'.
+func cropWindowSize(fullSize Dims, geom Geometry, exact bool) Dims {
+	if exact {
+		geom.x = GeometryDimension{0, geom.width.U}
+		geom.y = GeometryDimension{0, geom.width.U}
+		return geom.Crop(fullSize)
+	}
+	aspect := geom.width.N / geom.height.N
+	if fullSize.X()/fullSize.Y() > aspect {
+		return NewDims(math.Round(fullSize.Y()*aspect), fullSize.Y())
+	}
+	return NewDims(fullSize.X(), math.Round(fullSize.X()/aspect))
+}
+
+// Calculates the offset of the most salient crop window of the given size within an image.
+func smartCropOffset(imgData image.Image, fullSize Dims, windowSize Dims, method SmartCropMethod) Dims {
+	saliency, width, height := saliencyMap(imgData, method)
+	factor := NewDims(float64(width)/fullSize.X(), float64(height)/fullSize.Y())
+	winWidth := int(math.Max(1, math.Min(float64(width), math.Round(windowSize.X()*factor.X()))))
+	winHeight := int(math.Max(1, math.Min(float64(height), math.Round(windowSize.Y()*factor.Y()))))
+	x, y := mostSalientWindow(saliency, width, height, winWidth, winHeight)
+	return NewDims(
+		math.Max(0, math.Min(fullSize.X()-windowSize.X(), math.Round(float64(x)/factor.X()))),
+		math.Max(0, math.Min(fullSize.Y()-windowSize.Y(), math.Round(float64(y)/factor.Y()))))
+}
+
+type DimensionInitializer_SmartCrop_CustomParameters struct {
+	geometry string
+	exact    bool
+	method   string
+}
+
+func DimensionInitializer_SmartCrop_Init() DimensionInitializer_SmartCrop {
+	return DimensionInitializer_SmartCrop{new(DimensionInitializer_SmartCrop_CustomParameters)}
+}
+
+// A 'DimensionInitializer' that crops each image to a window of a given aspect ratio or size,
+// placing the window over the part of the image that a saliency measure finds most interesting.
+type DimensionInitializer_SmartCrop struct {
+	p *DimensionInitializer_SmartCrop_CustomParameters
+}
+
+func (dis DimensionInitializer_SmartCrop) RegisterCustomParameters(parameters *Parameters) bool {
+	flag.StringVar(&(dis.p.geometry), "smart-crop", "1x1", "(Smart crop) Crop each image to the largest window of this aspect ratio")
+	flag.BoolVar(&(dis.p.exact), "smart-crop-exact", false, "(Smart crop) Treat the -smart-crop geometry as the exact size of the window rather than an aspect ratio")
+	flag.StringVar(&(dis.p.method), "smart-crop-method", "entropy", "(Smart crop) Saliency measure used to place the window: 'entropy', 'edges', or 'contrast'")
+	return true
+}
+
+func (dis DimensionInitializer_SmartCrop) ParseCustomParameters(parameters *Parameters) bool {
+	geometry, err := ParseGeometry(dis.p.geometry)
+	if err != nil {
+		parameters.ProgressMonitor().ReportMessage(err.Error())
+		return false
+	}
+	if !geometry.HasWidth() || !geometry.HasHeight() {
+		parameters.ProgressMonitor().ReportMessage("-smart-crop geometry must specify both a width and a height")
+		return false
+	}
+	parameters.SetOther(SmartCrop_Geometry, geometry)
+	parameters.SetOther(SmartCrop_Exact, dis.p.exact)
+	switch strings.ToLower(dis.p.method) {
+	case "entropy":
+		parameters.SetOther(SmartCrop_Method, SmartCropEntropy)
+	case "edges":
+		parameters.SetOther(SmartCrop_Method, SmartCropEdges)
+	case "contrast":
+		parameters.SetOther(SmartCrop_Method, SmartCropContrast)
+	default:
+		parameters.ProgressMonitor().ReportMessage("-smart-crop-method value must be 'entropy', 'edges', or 'contrast'")
+		return false
+	}
+	return true
+}
+
+func (dis DimensionInitializer_SmartCrop) InitializeDimensions(imageLayout ImageLayout) (il ImageLayout, err error) {
+	geometry := imageLayout.Parameters().OtherGeometry(SmartCrop_Geometry)
+	exact := imageLayout.Parameters().OtherBool(SmartCrop_Exact)
+	method := SmartCropEntropy
+	if methodI, valid := imageLayout.Parameters().Other(SmartCrop_Method); valid {
+		switch methodI := methodI.(type) {
+		case SmartCropMethod:
+			method = methodI
+		default:
+		}
+	}
+	for _, img := range imageLayout.Images(false) {
+		info := imageLayout.ImageInfoOf(img)
+		imgData, ok := info.ImageData().(image.Image)
+		if !ok {
+			il, err = imageLayout, errors.New("smart cropping requires raster image data: "+info.FileName())
+			return
+		}
+		fullSize := info.DimensionsOf()
+		windowSize := cropWindowSize(fullSize, geometry, exact)
+		offset := smartCropOffset(imgData, fullSize, windowSize, method)
+		imageLayout.SetScaling(img, EmptyGeometry())
+		imageLayout.SetCropping(img, NewCroppingGeometry(windowSize, offset))
+	}
+	il, err = imageLayout, nil
+	return
+}
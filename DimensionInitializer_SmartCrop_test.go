@@ -0,0 +1,93 @@
+package CollageCreator
+
+import (
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+)
+
+func TestCropWindowSize(t *testing.T) {
+	cases := []struct {
+		fullSize Dims
+		geometry string
+		exact    bool
+		want     Dims
+	}{
+		{NewDims(400, 300), "1x1", false, NewDims(300, 300)},
+		{NewDims(300, 400), "1x1", false, NewDims(300, 300)},
+		{NewDims(400, 300), "16x9", false, NewDims(400, 225)},
+		{NewDims(400, 300), "100x50", true, NewDims(100, 50)},
+		{NewDims(400, 300), "50x50%", true, NewDims(200, 150)},
+	}
+	for _, c := range cases {
+		if got := cropWindowSize(c.fullSize, MustParseGeometry(c.geometry), c.exact); got != c.want {
+			t.Errorf("window '%s' (exact %v) of %v = %v, want %v", c.geometry, c.exact, c.fullSize, got, c.want)
+		}
+	}
+}
+
+func TestMostSalientWindow(t *testing.T) {
+	saliency := make([]float64, 10*4)
+	saliency[1*10+7] = 1
+	if x, y := mostSalientWindow(saliency, 10, 4, 2, 2); x != 6 || y != 1 {
+		t.Errorf("window at (%d, %d), want one covering the salient pixel nearest the centre, (6, 1)", x, y)
+	}
+	if x, y := mostSalientWindow(make([]float64, 10*4), 10, 4, 4, 4); x != 3 || y != 0 {
+		t.Errorf("window at (%d, %d) of a featureless map, want the central (3, 0)", x, y)
+	}
+}
+
+// Creates a flat grey image of 400x100 whose rightmost quarter is filled with random blocks.
+func newSalientTestImage() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 400, 100))
+	rnd := rand.New(rand.NewSource(1))
+	for by := 0; by < 100; by += 20 {
+		for bx := 0; bx < 400; bx += 20 {
+			c := color.RGBA{128, 128, 128, 255}
+			if bx >= 300 {
+				c = color.RGBA{uint8(rnd.Intn(256)), uint8(rnd.Intn(256)), uint8(rnd.Intn(256)), 255}
+			}
+			for y := by; y < by+20; y++ {
+				for x := bx; x < bx+20; x++ {
+					img.SetRGBA(x, y, c)
+				}
+			}
+		}
+	}
+	return img
+}
+
+func TestSmartCropFindsSalientRegion(t *testing.T) {
+	for _, method := range []string{"entropy", "edges", "contrast"} {
+		parameters := newTestParameters()
+		dis := DimensionInitializer_SmartCrop_Init()
+		dis.p.geometry, dis.p.method = "1x1", method
+		if !dis.ParseCustomParameters(parameters) {
+			t.Fatalf("%s: parsing failed", method)
+		}
+		imageLayout := CreateImageLayoutFromImages(parameters, []image.Image{newSalientTestImage()})
+		imageLayout, err := dis.InitializeDimensions(imageLayout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		img := imageLayout.Images(false)[0]
+		if dims := imageLayout.DimensionsOf(img); dims != NewDims(100, 100) {
+			t.Errorf("%s: cropped to %v, want 100x100", method, dims)
+		}
+		if offset := imageLayout.CroppingOf(img).Offset(NewDims(400, 100)); offset.X() < 280 {
+			t.Errorf("%s: window at %v, want it over the textured right end", method, offset)
+		}
+	}
+}
+
+func TestSmartCropParameters(t *testing.T) {
+	for _, c := range []struct{ geometry, method string }{{"1x1", "blur"}, {"100", "entropy"}, {"axb", "entropy"}} {
+		var messages []string
+		dis := DimensionInitializer_SmartCrop_Init()
+		dis.p.geometry, dis.p.method = c.geometry, c.method
+		if dis.ParseCustomParameters(newRecordingTestParameters(&messages)) || len(messages) != 1 {
+			t.Errorf("-smart-crop '%s' -smart-crop-method '%s' was accepted, reporting %q", c.geometry, c.method, messages)
+		}
+	}
+}
@@ -3,13 +3,17 @@ package CollageCreator
 import (
 	"flag"
 	"math"
+	"sort"
+	"strconv"
 	"strings"
 )
 
 const (
-	Uniform_Cropping   string = "Uniform_Cropping"
-	Uniform_Scaling    string = "Uniform_Scaling"
-	Uniform_ScaleToMin string = "Uniform_ScaleToMin"
+	Uniform_Cropping    string = "Uniform_Cropping"
+	Uniform_Scaling     string = "Uniform_Scaling"
+	Uniform_ScaleToMin  string = "Uniform_ScaleToMin"
+	Uniform_ScaleToArea string = "Uniform_ScaleToArea"
+	Uniform_NoUpscale   string = "Uniform_NoUpscale"
 )
 
 // The simplest 'DimensionInitializer': sends all images through as-is.
@@ -29,9 +33,11 @@ func (dio DimensionInitializer_Original) InitializeDimensions(imageLayout ImageL
 }
 
 type DimensionInitializer_Uniform_CustomParameters struct {
-	cropping   string
-	scaling    string
-	scaleToMin string
+	cropping    string
+	scaling     string
+	scaleToMin  string
+	scaleToArea string
+	noUpscale   bool
 }
 
 type DimensionInitializer_Uniform_ScaleToMinParameter struct {
@@ -39,6 +45,102 @@ type DimensionInitializer_Uniform_ScaleToMinParameter struct {
 	y bool
 }
 
+// The statistics of the input images' areas to which '-scale-to-area' may scale them.
+type DimensionInitializer_Uniform_AreaStatistic int
+
+const (
+	// Scale to a fixed area given in pixels.
+	AreaFixed DimensionInitializer_Uniform_AreaStatistic = iota
+	// Scale to the area of the smallest image.
+	AreaMin
+	// Scale to the median of the images' areas.
+	AreaMedian
+	// Scale to the mean of the images' areas.
+	AreaMean
+)
+
+type DimensionInitializer_Uniform_ScaleToAreaParameter struct {
+	statistic DimensionInitializer_Uniform_AreaStatistic
+	area      float64
+}
+
+// Calculates the area to which each image, once cropped according to 'cropping', is to be scaled.
+func (p DimensionInitializer_Uniform_ScaleToAreaParameter) targetArea(imageLayout ImageLayout, cropping Geometry) float64 {
+	areas := make([]float64, 0, imageLayout.TotalImageCount())
+	for _, img := range imageLayout.Images(false) {
+		dims := cropping.Crop(imageLayout.ImageInfoOf(img).DimensionsOf())
+		areas = append(areas, dims.X()*dims.Y())
+	}
+	if len(areas) == 0 {
+		return p.area
+	}
+	sort.Float64s(areas)
+	switch p.statistic {
+	case AreaMin:
+		return areas[0]
+	case AreaMedian:
+		if len(areas)%2 == 0 {
+			return (areas[len(areas)/2-1] + areas[len(areas)/2]) / 2
+		}
+		return areas[len(areas)/2]
+	case AreaMean:
+		sum := 0.0
+		for _, area := range areas {
+			sum += area
+		}
+		return sum / float64(len(areas))
+	default:
+		return p.area
+	}
+}
+
+// Parses a target area given as 'min', 'median', 'mean', or a positive number of pixels.
+func parseScaleToArea(arg string) (p DimensionInitializer_Uniform_ScaleToAreaParameter, valid bool) {
+	switch strings.ToLower(arg) {
+	case "min":
+		return DimensionInitializer_Uniform_ScaleToAreaParameter{statistic: AreaMin}, true
+	case "median":
+		return DimensionInitializer_Uniform_ScaleToAreaParameter{statistic: AreaMedian}, true
+	case "mean":
+		return DimensionInitializer_Uniform_ScaleToAreaParameter{statistic: AreaMean}, true
+	default:
+		area, err := strconv.ParseFloat(arg, 64)
+		if err != nil || area <= 0 {
+			return
+		}
+		return DimensionInitializer_Uniform_ScaleToAreaParameter{statistic: AreaFixed, area: area}, true
+	}
+}
+
+// Finds the factor by which to scale an image of size 'dims' so that, once cropped according to
+// 'cropping' as the renderer does after scaling, it has the area 'target'. A pixel crop caps the
+// area, whatever the factor; if the target lies beyond the cap, the smallest factor that reaches
+// the cap is returned.
+func scaleFactorForArea(dims Dims, cropping Geometry, target float64) float64 {
+	area := func(factor float64) float64 {
+		cropped := ScaleAndCrop(dims, cropping, NewScalingGeometry(NewDims(dims.X()*factor, dims.Y()*factor)))
+		return math.Max(0, cropped.X()) * math.Max(0, cropped.Y())
+	}
+	// The area grows with the factor; find a factor that reaches the target, or the cap.
+	low, high := 0.0, 1.0
+	for k := 0; k < 64 && area(high) < target; k++ {
+		if area(2*high) <= area(high) {
+			target = area(high)
+			break
+		}
+		low, high = high, 2*high
+	}
+	for k := 0; k < 100; k++ {
+		middle := (low + high) / 2
+		if area(middle) < target {
+			low = middle
+		} else {
+			high = middle
+		}
+	}
+	return high
+}
+
 func DimensionInitializer_Uniform_Init() DimensionInitializer_Uniform {
 	return DimensionInitializer_Uniform{new(DimensionInitializer_Uniform_CustomParameters)}
 }
@@ -53,6 +155,8 @@ func (dio DimensionInitializer_Uniform) RegisterCustomParameters(parameters *Par
 	flag.StringVar(&(dio.p.cropping), "crop", "", "Crop all images according to this geometry before processing")
 	flag.StringVar(&(dio.p.scaling), "scale", "", "Scale all images according to this geometry before processing")
 	flag.StringVar(&(dio.p.scaleToMin), "scale-to-min", "", "Scale all images to the dimensions of the smallest")
+	flag.StringVar(&(dio.p.scaleToArea), "scale-to-area", "", "Scale all images, preserving aspect ratio, to the same area: 'min', 'median', 'mean', or a number of pixels")
+	flag.BoolVar(&(dio.p.noUpscale), "no-upscale", false, "With -scale-to-area, never scale an image beyond its native resolution")
 	return true
 }
 
@@ -88,6 +192,19 @@ func (dio DimensionInitializer_Uniform) ParseCustomParameters(parameters *Parame
 			}
 		}
 	}
+	if dio.p.scaleToArea != "" {
+		if dio.p.scaling != "" || dio.p.scaleToMin != "" {
+			parameters.ProgressMonitor().ReportMessage("-scale-to-area cannot be combined with -scale or -scale-to-min")
+			return false
+		}
+		scaleToArea, valid := parseScaleToArea(dio.p.scaleToArea)
+		if !valid {
+			parameters.ProgressMonitor().ReportMessage("-scale-to-area value must be 'min', 'median', 'mean', or a positive number of pixels")
+			return false
+		}
+		parameters.SetOther(Uniform_ScaleToArea, scaleToArea)
+	}
+	parameters.SetOther(Uniform_NoUpscale, dio.p.noUpscale)
 	if dio.p.scaling == "" {
 		parameters.SetOther(Uniform_Scaling, EmptyGeometry())
 	} else {
@@ -129,9 +246,30 @@ func (dio DimensionInitializer_Uniform) InitializeDimensions(imageLayout ImageLa
 		default:
 		}
 	}
+	var targetArea float64 = math.NaN()
+	if scalingToArea, valid := imageLayout.Parameters().Other(Uniform_ScaleToArea); valid {
+		switch scalingToAreaO := scalingToArea.(type) {
+		case DimensionInitializer_Uniform_ScaleToAreaParameter:
+			targetArea = scalingToAreaO.targetArea(imageLayout, cropping)
+		default:
+		}
+	}
+	noUpscale := false
+	if noUpscaleI, valid := imageLayout.Parameters().Other(Uniform_NoUpscale); valid {
+		noUpscale, _ = noUpscaleI.(bool)
+	}
 	for _, img := range imageLayout.Images(false) {
 		imageLayout.SetCropping(img, cropping)
-		imageLayout.SetScaling(img, scaling)
+		if math.IsNaN(targetArea) {
+			imageLayout.SetScaling(img, scaling)
+			continue
+		}
+		dims := imageLayout.ImageInfoOf(img).DimensionsOf()
+		factor := scaleFactorForArea(dims, cropping, targetArea)
+		if noUpscale && factor > 1 {
+			factor = 1
+		}
+		imageLayout.SetScaling(img, NewScalingGeometry(NewDims(dims.X()*factor, dims.Y()*factor)))
 	}
 	il, err = imageLayout, nil
 	return
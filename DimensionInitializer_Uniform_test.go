@@ -0,0 +1,69 @@
+package CollageCreator
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestScaleToAreaUsesCroppedDimensions(t *testing.T) {
+	cases := []struct {
+		cropping string
+		target   string
+		sizes    []Dims
+		// The area of each image once scaled and cropped.
+		want float64
+	}{
+		{"50x100+0+0%", "10000", []Dims{NewDims(400, 200), NewDims(200, 400)}, 10000},
+		// A pixel crop larger than the scaled image leaves it whole.
+		{"300x300+0+0", "10000", []Dims{NewDims(400, 200)}, 10000},
+		// A pixel crop that cuts into the scaled image, from an offset.
+		{"100x100+50+50", "5000", []Dims{NewDims(400, 200), NewDims(200, 400)}, 5000},
+		// A pixel crop caps the area, however far the image is scaled.
+		{"100x100+0+0", "1000000", []Dims{NewDims(400, 200)}, 10000},
+		// The mean of the areas as cropped at native resolution.
+		{"100x100+0+0", "mean", []Dims{NewDims(400, 200), NewDims(50, 50)}, (10000 + 2500) / 2},
+	}
+	for _, c := range cases {
+		parameters := newTestParameters()
+		dio := DimensionInitializer_Uniform_Init()
+		dio.p.cropping = c.cropping
+		dio.p.scaleToArea = c.target
+		if !dio.ParseCustomParameters(parameters) {
+			t.Fatal("parameters rejected")
+		}
+		imageLayout, err := dio.InitializeDimensions(newTestLayout(parameters, c.sizes...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, img := range imageLayout.Images(false) {
+			dims := imageLayout.DimensionsOf(img)
+			assertNear(t, fmt.Sprintf("area of %v cropped to %s, scaled to %s", imageLayout.ImageInfoOf(img).DimensionsOf(), c.cropping, c.target), dims.X()*dims.Y(), c.want, 1e-6*c.want)
+		}
+	}
+}
+
+func TestScaleToAreaWithoutNoUpscaleParameter(t *testing.T) {
+	// A library caller may set the parameters of the baseline initializer alone.
+	parameters := newTestParameters()
+	parameters.SetOther(Uniform_Cropping, EmptyGeometry())
+	parameters.SetOther(Uniform_Scaling, MustParseGeometry("50%"))
+	imageLayout, err := DimensionInitializer_Uniform_Init().InitializeDimensions(newTestLayout(parameters, NewDims(400, 200)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dims := imageLayout.DimensionsOf(imageLayout.Images(false)[0]); dims != NewDims(200, 100) {
+		t.Errorf("scaled to %v, want 200x100", dims)
+	}
+}
+
+func TestScaleToAreaRejectsOtherScaling(t *testing.T) {
+	for _, other := range []struct{ scaling, scaleToMin string }{{"50%", ""}, {"", "xy"}} {
+		var messages []string
+		dio := DimensionInitializer_Uniform_Init()
+		dio.p.scaling, dio.p.scaleToMin = other.scaling, other.scaleToMin
+		dio.p.scaleToArea = "median"
+		if dio.ParseCustomParameters(newRecordingTestParameters(&messages)) || len(messages) == 0 {
+			t.Errorf("-scale-to-area with %+v was accepted", other)
+		}
+	}
+}
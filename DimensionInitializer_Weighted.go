@@ -0,0 +1,192 @@
+package CollageCreator
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	Weighted_Manifest  string = "Weighted_Manifest"
+	Weighted_UseRating string = "Weighted_UseRating"
+	Weighted_BaseArea  string = "Weighted_BaseArea"
+)
+
+// Matches a weight given as a suffix to the base name of an image file, e.g. 'beach@2.jpg'.
+var weightSuffixRegex *regexp.Regexp = regexp.MustCompile(`@([0-9]+(\.[0-9]+)?)$`)
+
+// Normalizes a pathname for use as a key in a weight manifest.
+func weightManifestKey(fileName string) string {
+	if abs, err := filepath.Abs(fileName); err == nil {
+		return filepath.Clean(abs)
+	}
+	return filepath.Clean(fileName)
+}
+
+// Reads a weight manifest: either a CSV file whose rows hold a pathname and a weight,
+// or a JSON file holding an object mapping pathnames to weights. Relative pathnames
+// are resolved against the directory holding the manifest.
+func ReadWeightManifest(fileName string) (weights map[string]float64, err error) {
+	contents, err := os.ReadFile(fileName)
+	if err != nil {
+		return
+	}
+	raw := map[string]float64{}
+	if strings.ToLower(filepath.Ext(fileName)) == ".json" {
+		if err = json.Unmarshal(contents, &raw); err != nil {
+			return
+		}
+	} else {
+		reader := csv.NewReader(strings.NewReader(string(contents)))
+		reader.FieldsPerRecord = -1
+		var records [][]string
+		if records, err = reader.ReadAll(); err != nil {
+			return
+		}
+		for i, record := range records {
+			if len(record) < 2 {
+				continue
+			}
+			weight, errP := strconv.ParseFloat(strings.TrimSpace(record[1]), 64)
+			if errP != nil {
+				if i == 0 {
+					// A header row
+					continue
+				}
+				err = fmt.Errorf("%s, line %d: %w", fileName, i+1, errP)
+				return
+			}
+			raw[strings.TrimSpace(record[0])] = weight
+		}
+	}
+	weights = map[string]float64{}
+	for path, weight := range raw {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(filepath.Dir(fileName), path)
+		}
+		weights[weightManifestKey(path)] = weight
+	}
+	return
+}
+
+// Determines the importance weight of an image: from the input manifest if it gives one,
+// otherwise from the weight manifest if it is listed there, otherwise from a '@weight' suffix on its file name, otherwise (if 'useRating' is set)
+// from its XMP 'xmp:Rating', and otherwise 1.
+func imageWeight(info ImageInfo, manifest map[string]float64, useRating bool) (weight float64, source string) {
+	if annotations, _ := AnnotationsOf(info); annotations.WeightGiven {
+		return annotations.Weight, "input manifest"
+	}
+	fileName := info.FileName()
+	if weight, in := manifest[weightManifestKey(fileName)]; in {
+		return weight, "manifest"
+	}
+	base := strings.TrimSuffix(filepath.Base(fileName), filepath.Ext(fileName))
+	if sm := weightSuffixRegex.FindStringSubmatch(base); sm != nil {
+		if weight, err := strconv.ParseFloat(sm[1], 64); err == nil {
+			return weight, "file name"
+		}
+	}
+	if useRating {
+		if packet, found := readXMPPacket(fileName); found {
+			if rating, err := strconv.ParseFloat(xmpProperties(packet)["Rating"], 64); err == nil {
+				return math.Max(1, rating), "rating"
+			}
+		}
+	}
+	return 1, "default"
+}
+
+type DimensionInitializer_Weighted_CustomParameters struct {
+	manifest  string
+	useRating bool
+	baseArea  string
+}
+
+func DimensionInitializer_Weighted_Init() DimensionInitializer_Weighted {
+	return DimensionInitializer_Weighted{new(DimensionInitializer_Weighted_CustomParameters)}
+}
+
+// A 'DimensionInitializer' that assigns each image an importance weight, taken from a manifest,
+// a file-name suffix, or an XMP rating, and scales each image so that its area is proportional
+// to its weight, keeping its aspect ratio.
+type DimensionInitializer_Weighted struct {
+	p *DimensionInitializer_Weighted_CustomParameters
+}
+
+func (diw DimensionInitializer_Weighted) RegisterCustomParameters(parameters *Parameters) bool {
+	flag.StringVar(&(diw.p.manifest), "weight-manifest", "", "(Weighted) CSV or JSON file giving the weight of each image")
+	flag.BoolVar(&(diw.p.useRating), "weight-rating", false, "(Weighted) Use each image's XMP rating as its weight when none is otherwise given")
+	flag.StringVar(&(diw.p.baseArea), "weight-base-area", "median", "(Weighted) Area of an image of weight 1: 'min', 'median', 'mean', or a number of pixels")
+	return true
+}
+
+func (diw DimensionInitializer_Weighted) ParseCustomParameters(parameters *Parameters) bool {
+	manifest := map[string]float64{}
+	if diw.p.manifest != "" {
+		var err error
+		manifest, err = ReadWeightManifest(diw.p.manifest)
+		if err != nil {
+			parameters.ProgressMonitor().ReportMessage(err.Error())
+			return false
+		}
+	}
+	parameters.SetOther(Weighted_Manifest, manifest)
+	parameters.SetOther(Weighted_UseRating, diw.p.useRating)
+	baseArea, valid := parseScaleToArea(diw.p.baseArea)
+	if !valid {
+		parameters.ProgressMonitor().ReportMessage("-weight-base-area value must be 'min', 'median', 'mean', or a positive number of pixels")
+		return false
+	}
+	parameters.SetOther(Weighted_BaseArea, baseArea)
+	return true
+}
+
+func (diw DimensionInitializer_Weighted) InitializeDimensions(imageLayout ImageLayout) (il ImageLayout, err error) {
+	parameters := imageLayout.Parameters()
+	manifest := map[string]float64{}
+	if manifestI, valid := parameters.Other(Weighted_Manifest); valid {
+		manifest, _ = manifestI.(map[string]float64)
+	}
+	useRating := parameters.OtherBool(Weighted_UseRating)
+	baseArea := DimensionInitializer_Uniform_ScaleToAreaParameter{statistic: AreaMedian}
+	if baseAreaI, valid := parameters.Other(Weighted_BaseArea); valid {
+		switch baseAreaI := baseAreaI.(type) {
+		case DimensionInitializer_Uniform_ScaleToAreaParameter:
+			baseArea = baseAreaI
+		default:
+		}
+	}
+	area := baseArea.targetArea(imageLayout, EmptyGeometry())
+	for _, img := range imageLayout.Images(false) {
+		info := imageLayout.ImageInfoOf(img)
+		weight, source := imageWeight(info, manifest, useRating)
+		if weight <= 0 {
+			il, err = imageLayout, errors.New("weight must be positive: "+info.FileName())
+			return
+		}
+		if source != "default" {
+			parameters.ProgressMonitor().ReportMessage(fmt.Sprintf("%s: weight %g (from %s)", info.FileName(), weight, source))
+		}
+		imageLayout = imageLayout.SetWeight(img, weight)
+		// The crop given by an input manifest is kept, and the area is that of the cropped image.
+		// (Within a chain, the image is presented already cropped.)
+		dims := info.DimensionsOf()
+		cropping := EmptyGeometry()
+		if annotated, ok := info.(AnnotatedImageInfo); ok {
+			cropping = annotated.Annotations().Cropping
+		}
+		factor := scaleFactorForArea(dims, cropping, area*weight)
+		imageLayout.SetCropping(img, cropping)
+		imageLayout.SetScaling(img, NewScalingGeometry(NewDims(dims.X()*factor, dims.Y()*factor)))
+	}
+	il, err = imageLayout, nil
+	return
+}
@@ -0,0 +1,79 @@
+package CollageCreator
+
+import (
+	"fmt"
+	"image"
+	"testing"
+)
+
+// Creates a layout of blank images of the given sizes, each annotated as by an input manifest.
+func newAnnotatedTestLayout(parameters *Parameters, sizes []Dims, annotations []ImageAnnotations) ImageLayout {
+	infos := make([]ImageInfo, len(sizes))
+	for i, size := range sizes {
+		img := image.NewGray(image.Rect(0, 0, int(size.X()), int(size.Y())))
+		infos[i] = ImageInfo_annotated{NewImageInfoFromImage(ImageIdentifier(i), fmt.Sprintf("image %d", i+1), img), annotations[i]}
+	}
+	imageLayout := createImageLayout(parameters, infos)
+	for i, img := range imageLayout.Images(false) {
+		imageLayout = imageLayout.SetWeight(img, annotations[i].Weight)
+		imageLayout.SetCropping(img, annotations[i].Cropping)
+		imageLayout.SetScaling(img, annotations[i].Scaling)
+	}
+	return imageLayout
+}
+
+func newTestWeighted(t *testing.T, parameters *Parameters) DimensionInitializer_Weighted {
+	diw := DimensionInitializer_Weighted_Init()
+	diw.p.baseArea = "10000"
+	if !diw.ParseCustomParameters(parameters) {
+		t.Fatal("parameters rejected")
+	}
+	return diw
+}
+
+func TestWeightedUsesInputManifest(t *testing.T) {
+	annotations := []ImageAnnotations{
+		{Weight: 4, WeightGiven: true, Cropping: EmptyGeometry(), Scaling: EmptyGeometry()},
+		// A weight left unset falls back to the file name, or to 1.
+		{Weight: 1, Cropping: EmptyGeometry(), Scaling: EmptyGeometry()},
+		// A crop from the manifest is kept, and the weighted area is that of the cropped image.
+		{Weight: 2, WeightGiven: true, Cropping: MustParseGeometry("50x50+0+0%"), Scaling: EmptyGeometry()},
+	}
+	sizes := []Dims{NewDims(100, 100), NewDims(200, 100), NewDims(200, 100)}
+	wants := []float64{40000, 10000, 20000}
+	for _, chained := range []bool{false, true} {
+		parameters := newTestParameters()
+		diw := newTestWeighted(t, parameters)
+		imageLayout := newAnnotatedTestLayout(parameters, sizes, annotations)
+		var err error
+		if chained {
+			imageLayout, err = RunDimensionInitializerChain(imageLayout, []DimensionInitializer{diw})
+		} else {
+			imageLayout, err = diw.InitializeDimensions(imageLayout)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, img := range imageLayout.Images(false) {
+			dims := imageLayout.DimensionsOf(img)
+			assertNear(t, fmt.Sprintf("chained %v: area of image %d", chained, i+1), dims.X()*dims.Y(), wants[i], 1e-6*wants[i])
+			assertNear(t, fmt.Sprintf("chained %v: weight of image %d", chained, i+1), imageLayout.WeightOf(img), annotations[i].Weight, 0)
+			if i == 2 {
+				assertNear(t, "aspect ratio of the cropped image", dims.X()/dims.Y(), 2, 1e-9)
+			}
+		}
+	}
+}
+
+func TestChainStartsFromInputManifest(t *testing.T) {
+	parameters := newTestParameters()
+	annotations := []ImageAnnotations{{Weight: 1, Cropping: MustParseGeometry("100x100+0+0"), Scaling: MustParseGeometry("50%")}}
+	imageLayout := newAnnotatedTestLayout(parameters, []Dims{NewDims(400, 300)}, annotations)
+	imageLayout, err := RunDimensionInitializerChain(imageLayout, []DimensionInitializer{DimensionInitializer_Original{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dims := imageLayout.DimensionsOf(imageLayout.Images(false)[0]); dims != NewDims(100, 100) {
+		t.Errorf("chain left the image at %v, want the manifest's 100x100", dims)
+	}
+}
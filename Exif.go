@@ -0,0 +1,299 @@
+// This file contains auxiliary methods that read EXIF metadata from JPEG and
+// TIFF files. Chiefly, they read the orientation tag and apply it to decoded
+// images, so that images are laid out and rendered in the orientation in which
+// they are meant to be displayed.
+package CollageCreator
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	exif_OrientationTag      uint16 = 0x0112
+	exif_DateTimeTag         uint16 = 0x0132
+	exif_SubIFDTag           uint16 = 0x8769
+	exif_DateTimeOriginalTag uint16 = 0x9003
+	exif_TypeASCII           uint16 = 2
+	exif_TypeShort           uint16 = 3
+)
+
+// Implemented by an 'ImageInfo' that reads its image from a file stored in an orientation
+// other than the one in which it is displayed. 'DimensionsOf' and 'ImageData' of such an
+// 'ImageInfo' reflect the display orientation; renderers that refer to the file directly
+// use 'Orientation' to display it correctly.
+type OrientedImageInfo interface {
+	ImageInfo
+	// Gets the EXIF orientation (1 through 8) in which the image file is stored.
+	Orientation() int
+}
+
+// Gets the EXIF orientation of the given image, or 1 if it does not have one.
+func OrientationOf(info ImageInfo) int {
+	if oriented, ok := info.(OrientedImageInfo); ok {
+		return oriented.Orientation()
+	}
+	return 1
+}
+
+// An entry of a TIFF image file directory.
+type tiffEntry struct {
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+// The TIFF structure holding an image's EXIF metadata.
+type tiffStructure struct {
+	r     io.ReaderAt
+	order binary.ByteOrder
+	ifd0  int64
+}
+
+// Reads the header of a TIFF structure.
+func readTiffHeader(r io.ReaderAt) (ts tiffStructure, valid bool) {
+	header := make([]byte, 8)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return
+	}
+	switch string(header[0:2]) {
+	case "II":
+		ts.order = binary.LittleEndian
+	case "MM":
+		ts.order = binary.BigEndian
+	default:
+		return
+	}
+	if ts.order.Uint16(header[2:4]) != 42 {
+		return
+	}
+	ts.r, ts.ifd0, valid = r, int64(ts.order.Uint32(header[4:8])), true
+	return
+}
+
+// Reads the entries of the image file directory at the given offset, keyed by tag.
+func (ts tiffStructure) readIFD(offset int64) map[uint16]tiffEntry {
+	rv := map[uint16]tiffEntry{}
+	countBytes := make([]byte, 2)
+	if _, err := ts.r.ReadAt(countBytes, offset); err != nil {
+		return rv
+	}
+	for i := int64(0); i < int64(ts.order.Uint16(countBytes)); i++ {
+		entry := make([]byte, 12)
+		if _, err := ts.r.ReadAt(entry, offset+2+12*i); err != nil {
+			return rv
+		}
+		rv[ts.order.Uint16(entry[0:2])] = tiffEntry{ts.order.Uint16(entry[2:4]), ts.order.Uint32(entry[4:8]), entry[8:12]}
+	}
+	return rv
+}
+
+// Reads the value of an ASCII entry, which is stored elsewhere if it is longer than four bytes.
+func (ts tiffStructure) readASCII(entry tiffEntry) string {
+	if entry.typ != exif_TypeASCII {
+		return ""
+	}
+	value := entry.value
+	if entry.count > 4 {
+		value = make([]byte, entry.count)
+		if _, err := ts.r.ReadAt(value, int64(ts.order.Uint32(entry.value))); err != nil {
+			return ""
+		}
+	} else {
+		value = value[:entry.count]
+	}
+	return strings.TrimRight(string(value), "\x00 ")
+}
+
+// Finds the TIFF structure holding the EXIF metadata of a JPEG or TIFF file.
+func findExifTiff(r io.ReaderAt) (ts tiffStructure, valid bool) {
+	magic := make([]byte, 4)
+	if _, err := r.ReadAt(magic, 0); err != nil {
+		return
+	}
+	switch {
+	case string(magic) == "II*\x00" || string(magic) == "MM\x00*":
+		return readTiffHeader(r)
+	case magic[0] == 0xff && magic[1] == 0xd8:
+		segment := make([]byte, 10)
+		for pos := int64(2); ; {
+			if _, err := r.ReadAt(segment[0:4], pos); err != nil || segment[0] != 0xff {
+				return
+			}
+			marker, length := segment[1], int64(binary.BigEndian.Uint16(segment[2:4]))
+			if marker == 0xda || marker == 0xd9 {
+				// Start of scan or end of image: no more metadata
+				return
+			}
+			if marker == 0xe1 && length >= 8 {
+				if _, err := r.ReadAt(segment[4:10], pos+4); err == nil && string(segment[4:10]) == "Exif\x00\x00" {
+					return readTiffHeader(io.NewSectionReader(r, pos+10, length-8))
+				}
+			}
+			pos += 2 + length
+		}
+	}
+	return
+}
+
+// Reads the EXIF orientation of a JPEG or TIFF file, returning 1 (the normal
+// orientation) if the file is of some other type or has no orientation tag.
+func ReadExifOrientation(r io.ReaderAt) int {
+	ts, valid := findExifTiff(r)
+	if !valid {
+		return 1
+	}
+	entry, in := ts.readIFD(ts.ifd0)[exif_OrientationTag]
+	if !in || entry.typ != exif_TypeShort {
+		return 1
+	}
+	if orientation := int(ts.order.Uint16(entry.value[0:2])); orientation >= 1 && orientation <= 8 {
+		return orientation
+	}
+	return 1
+}
+
+// Reads the time at which a JPEG or TIFF file was captured, from the EXIF 'DateTimeOriginal'
+// tag or, failing that, the 'DateTime' tag. Returns false if neither is present.
+func ReadExifCaptureTime(r io.ReaderAt) (t time.Time, valid bool) {
+	ts, valid := findExifTiff(r)
+	if !valid {
+		return
+	}
+	ifd0 := ts.readIFD(ts.ifd0)
+	var stamp string
+	if pointer, in := ifd0[exif_SubIFDTag]; in {
+		if entry, in := ts.readIFD(int64(ts.order.Uint32(pointer.value)))[exif_DateTimeOriginalTag]; in {
+			stamp = ts.readASCII(entry)
+		}
+	}
+	if entry, in := ifd0[exif_DateTimeTag]; stamp == "" && in {
+		stamp = ts.readASCII(entry)
+	}
+	t, err := time.Parse("2006:01:02 15:04:05", stamp)
+	valid = err == nil
+	return
+}
+
+// Converts the dimensions of an image as stored into its dimensions as displayed.
+func OrientedDims(stored Dims, orientation int) Dims {
+	if orientation >= 5 {
+		return NewDims(stored.Y(), stored.X())
+	}
+	return stored
+}
+
+// Gets where the pixel at (x, y) of a 'w' by 'h' image stored in the given EXIF orientation
+// lies once the image is displayed in the normal one.
+func orientedPoint(orientation, x, y, w, h int) (dx, dy int) {
+	switch orientation {
+	case 2:
+		return w - 1 - x, y
+	case 3:
+		return w - 1 - x, h - 1 - y
+	case 4:
+		return x, h - 1 - y
+	case 5:
+		return y, x
+	case 6:
+		return h - 1 - y, x
+	case 7:
+		return h - 1 - y, w - 1 - x
+	case 8:
+		return y, w - 1 - x
+	}
+	return x, y
+}
+
+// Copies the 4-byte pixels of a 'w' by 'h' image stored in the given EXIF orientation, starting
+// at the beginning of 'src', into 'dst' in the normal orientation.
+func orientPixels(dst []uint8, dstStride int, src []uint8, srcStride, w, h, orientation int) {
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dx, dy := orientedPoint(orientation, x, y, w, h)
+			copy(dst[dy*dstStride+4*dx:dy*dstStride+4*dx+4], src[y*srcStride+4*x:y*srcStride+4*x+4])
+		}
+	}
+}
+
+// Rotates and flips a decoded image from the given EXIF orientation into the normal one.
+func ApplyExifOrientation(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	displayed := OrientedDims(NewDims(float64(w), float64(h)), orientation)
+	rect := image.Rect(0, 0, int(displayed.X()), int(displayed.Y()))
+	// The types that the JPEG and PNG decoders produce for most photographs are copied
+	// directly between pixel buffers.
+	switch src := img.(type) {
+	case *image.RGBA:
+		rv := image.NewRGBA(rect)
+		orientPixels(rv.Pix, rv.Stride, src.Pix[src.PixOffset(bounds.Min.X, bounds.Min.Y):], src.Stride, w, h, orientation)
+		return rv
+	case *image.NRGBA:
+		rv := image.NewNRGBA(rect)
+		orientPixels(rv.Pix, rv.Stride, src.Pix[src.PixOffset(bounds.Min.X, bounds.Min.Y):], src.Stride, w, h, orientation)
+		return rv
+	case *image.YCbCr:
+		rv := image.NewNRGBA(rect)
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				yi, ci := src.YOffset(bounds.Min.X+x, bounds.Min.Y+y), src.COffset(bounds.Min.X+x, bounds.Min.Y+y)
+				r, g, b := color.YCbCrToRGB(src.Y[yi], src.Cb[ci], src.Cr[ci])
+				dx, dy := orientedPoint(orientation, x, y, w, h)
+				i := rv.PixOffset(dx, dy)
+				rv.Pix[i], rv.Pix[i+1], rv.Pix[i+2], rv.Pix[i+3] = r, g, b, 0xff
+			}
+		}
+		return rv
+	}
+	var rv draw.Image
+	switch img.ColorModel() {
+	case color.RGBA64Model, color.NRGBA64Model, color.Gray16Model:
+		rv = image.NewNRGBA64(rect)
+	default:
+		rv = image.NewNRGBA(rect)
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dx, dy := orientedPoint(orientation, x, y, w, h)
+			rv.Set(dx, dy, img.At(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+	return rv
+}
+
+// Calculates the SVG transform that displays an image stored in the given EXIF orientation,
+// drawn with its upper left corner at the origin, within the rectangle at 'origin' whose
+// dimensions as displayed are 'displayed'.
+func svgOrientationTransform(origin, displayed Dims, orientation int) string {
+	x0, y0, w, h := origin.X(), origin.Y(), displayed.X(), displayed.Y()
+	var a, b, c, d, e, f float64
+	switch orientation {
+	case 2:
+		a, b, c, d, e, f = -1, 0, 0, 1, x0+w, y0
+	case 3:
+		a, b, c, d, e, f = -1, 0, 0, -1, x0+w, y0+h
+	case 4:
+		a, b, c, d, e, f = 1, 0, 0, -1, x0, y0+h
+	case 5:
+		a, b, c, d, e, f = 0, 1, 1, 0, x0, y0
+	case 6:
+		a, b, c, d, e, f = 0, 1, -1, 0, x0+w, y0
+	case 7:
+		a, b, c, d, e, f = 0, -1, -1, 0, x0+w, y0+h
+	case 8:
+		a, b, c, d, e, f = 0, -1, 1, 0, x0, y0+h
+	default:
+		a, b, c, d, e, f = 1, 0, 0, 1, x0, y0
+	}
+	return fmt.Sprintf("matrix(%f %f %f %f %f %f)", a, b, c, d, e, f)
+}
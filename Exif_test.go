@@ -0,0 +1,72 @@
+package CollageCreator
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// Creates images of several types, each 3x2 pixels with a distinct colour per pixel, whose
+// pixel (0, 0) lies at 'min'.
+func orientationTestImages(min image.Point) map[string]image.Image {
+	rect := image.Rect(min.X, min.Y, min.X+3, min.Y+2)
+	rgba, nrgba, gray := image.NewRGBA(rect), image.NewNRGBA(rect), image.NewGray16(rect)
+	ycbcr := image.NewYCbCr(rect, image.YCbCrSubsampleRatio444)
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 3; x++ {
+			v := uint8(40 * (3*y + x + 1))
+			rgba.SetRGBA(min.X+x, min.Y+y, color.RGBA{v, 255 - v, v / 2, 255})
+			nrgba.SetNRGBA(min.X+x, min.Y+y, color.NRGBA{v, 255 - v, v / 2, 128})
+			gray.SetGray16(min.X+x, min.Y+y, color.Gray16{uint16(v) << 8})
+			yi, ci := ycbcr.YOffset(min.X+x, min.Y+y), ycbcr.COffset(min.X+x, min.Y+y)
+			ycbcr.Y[yi], ycbcr.Cb[ci], ycbcr.Cr[ci] = v, 255-v, v/2
+		}
+	}
+	return map[string]image.Image{"RGBA": rgba, "NRGBA": nrgba, "YCbCr": ycbcr, "Gray16": gray}
+}
+
+// Whether two colours differ by at most one 8-bit step in every channel, as converting
+// Y'CbCr to RGB at 8 bits rather than 16 may.
+func nearColor(a, b color.NRGBA64) bool {
+	near := func(u, v uint16) bool { return u-v <= 0x101 || v-u <= 0x101 }
+	return near(a.R, b.R) && near(a.G, b.G) && near(a.B, b.B) && near(a.A, b.A)
+}
+
+func TestApplyExifOrientation(t *testing.T) {
+	// Where the stored pixel (x, y) of a 3x2 image is displayed, for each orientation.
+	displayedAt := map[int]func(x, y int) (int, int){
+		2: func(x, y int) (int, int) { return 2 - x, y },
+		3: func(x, y int) (int, int) { return 2 - x, 1 - y },
+		4: func(x, y int) (int, int) { return x, 1 - y },
+		5: func(x, y int) (int, int) { return y, x },
+		6: func(x, y int) (int, int) { return 1 - y, x },
+		7: func(x, y int) (int, int) { return 1 - y, 2 - x },
+		8: func(x, y int) (int, int) { return y, 2 - x },
+	}
+	for _, min := range []image.Point{{0, 0}, {5, 7}} {
+		for name, img := range orientationTestImages(min) {
+			for orientation := 2; orientation <= 8; orientation++ {
+				what := fmt.Sprintf("%s at %v in orientation %d", name, min, orientation)
+				oriented := ApplyExifOrientation(img, orientation)
+				want := image.Rect(0, 0, 3, 2)
+				if orientation >= 5 {
+					want = image.Rect(0, 0, 2, 3)
+				}
+				if oriented.Bounds() != want {
+					t.Errorf("%s: bounds %v, want %v", what, oriented.Bounds(), want)
+					continue
+				}
+				for y := 0; y < 2; y++ {
+					for x := 0; x < 3; x++ {
+						dx, dy := displayedAt[orientation](x, y)
+						got := color.NRGBA64Model.Convert(oriented.At(dx, dy)).(color.NRGBA64)
+						if stored := color.NRGBA64Model.Convert(img.At(min.X+x, min.Y+y)).(color.NRGBA64); !nearColor(got, stored) {
+							t.Errorf("%s: pixel (%d, %d) displayed at (%d, %d) as %v, want %v", what, x, y, dx, dy, got, stored)
+						}
+					}
+				}
+			}
+		}
+	}
+}
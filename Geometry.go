@@ -183,6 +183,27 @@ func EmptyGeometry() Geometry {
 		scaling:             ScaleAlways}
 }
 
+// Creates a 'Geometry' object that scales an image to exactly the given size,
+// disregarding its original aspect ratio.
+func NewScalingGeometry(size Dims) Geometry {
+	geom := EmptyGeometry()
+	geom.width = GeometryDimension{size.X(), Pixels}
+	geom.height = GeometryDimension{size.Y(), Pixels}
+	geom.preserveAspectRatio = false
+	return geom
+}
+
+// Creates a 'Geometry' object that crops a box of the given size, in pixels,
+// whose upper left corner lies at the given offset.
+func NewCroppingGeometry(size Dims, offset Dims) Geometry {
+	geom := EmptyGeometry()
+	geom.width = GeometryDimension{size.X(), Pixels}
+	geom.height = GeometryDimension{size.Y(), Pixels}
+	geom.x = GeometryDimension{offset.X(), Pixels}
+	geom.y = GeometryDimension{offset.Y(), Pixels}
+	return geom
+}
+
 // Parses a string into a 'Geometry' object. The string must follow the format
 // of an ImageMagick 'geometry' parameter.
 // Returns the parsed object, and an error if the string is malformed.
@@ -454,3 +475,30 @@ func (geom Geometry) Scale(fullSize Dims) Dims {
 func ScaleAndCrop(original Dims, cropping Geometry, scaling Geometry) Dims {
 	return cropping.Crop(scaling.Scale(original))
 }
+
+// Composes two successive scale-and-crop operations into one. Returns geometries, in pixels,
+// that have the same effect on an image of size 'original' as applying 'scaling1' and
+// 'cropping1' and then applying 'scaling2' and 'cropping2' to the result.
+func ComposeScaleAndCrop(original Dims, scaling1, cropping1, scaling2, cropping2 Geometry) (scaling, cropping Geometry) {
+	scaled1 := scaling1.Scale(original)
+	offset1, cropped1 := NewDims(0, 0), scaled1
+	if cropping1.HasOffset() {
+		offset1, cropped1 = cropping1.Offset(scaled1), cropping1.Crop(scaled1)
+	}
+	scaled2 := scaling2.Scale(cropped1)
+	offset2, cropped2 := NewDims(0, 0), scaled2
+	if cropping2.HasOffset() {
+		offset2, cropped2 = cropping2.Offset(scaled2), cropping2.Crop(scaled2)
+	}
+	factor := NewDims(scaled2.X()/cropped1.X(), scaled2.Y()/cropped1.Y())
+	scaled := NewDims(scaled1.X()*factor.X(), scaled1.Y()*factor.Y())
+	offset := NewDims(offset1.X()*factor.X()+offset2.X(), offset1.Y()*factor.Y()+offset2.Y())
+	scaling, cropping = EmptyGeometry(), EmptyGeometry()
+	if scaled != original {
+		scaling = NewScalingGeometry(scaled)
+	}
+	if offset != NewDims(0, 0) || cropped2 != scaled {
+		cropping = NewCroppingGeometry(cropped2, offset)
+	}
+	return
+}
@@ -0,0 +1,286 @@
+// This file contains auxiliary methods that expand an animated GIF into its
+// individual frames, each named by the GIF's file name followed by '#frame'
+// and the frame's zero-based index (e.g. 'clip.gif#frame12'). Each frame is
+// composited onto the frames before it as the GIF's disposal methods specify,
+// so that it shows the full picture as displayed at that point in the animation.
+package CollageCreator
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Separates a GIF's file name from the index of a frame within it.
+const gifFrameSeparator string = "#frame"
+
+// Splits a name of the form 'file.gif#frameN' into the file name and the frame index.
+// Returns false if the name does not refer to a frame.
+func SplitFrameName(name string) (fileName string, frame int, isFrame bool) {
+	i := strings.LastIndex(name, gifFrameSeparator)
+	if i < 0 {
+		return name, 0, false
+	}
+	frame, err := strconv.Atoi(name[i+len(gifFrameSeparator):])
+	if err != nil || frame < 0 {
+		return name, 0, false
+	}
+	return name[:i], frame, true
+}
+
+// Names a frame of a GIF.
+func gifFrameName(fileName string, frame int) string {
+	return fmt.Sprintf("%s%s%d", fileName, gifFrameSeparator, frame)
+}
+
+// Composites the frames of an animated GIF, respecting their disposal methods, into
+// full-size images.
+func compositeGifFrames(g *gif.GIF) []image.Image {
+	bounds := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	canvas := image.NewNRGBA(bounds)
+	frames := make([]image.Image, len(g.Image))
+	for i, frame := range g.Image {
+		disposal := byte(gif.DisposalNone)
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		var previous *image.NRGBA
+		if disposal == gif.DisposalPrevious {
+			previous = image.NewNRGBA(bounds)
+			draw.Draw(previous, bounds, canvas, image.Point{}, draw.Src)
+		}
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		composited := image.NewNRGBA(bounds)
+		draw.Draw(composited, bounds, canvas, image.Point{}, draw.Src)
+		frames[i] = composited
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+	return frames
+}
+
+// Decodes all the frames of an animated GIF, which may be within an archive.
+func decodeGifFrames(fileName string) (frames []image.Image, err error) {
+	reader, closer, err := openImageSource(fileName)
+	if err != nil {
+		return
+	}
+	defer closer()
+	g, err := gif.DecodeAll(reader)
+	if err != nil {
+		err = imageDecodeError(fileName, reader, err)
+		return
+	}
+	frames = compositeGifFrames(g)
+	return
+}
+
+// The composited frames of an animated GIF, decoded once however many of its frames are used.
+type gifFrameSet struct {
+	once   sync.Once
+	frames []image.Image
+	err    error
+}
+
+// The frames of the animated GIFs decoded so far, by file name.
+var gifFrameSets = struct {
+	sync.Mutex
+	sets map[string]*gifFrameSet
+}{sets: map[string]*gifFrameSet{}}
+
+// Gets the composited frames of an animated GIF, decoding them if they have not been already.
+func gifFramesOf(fileName string) ([]image.Image, error) {
+	gifFrameSets.Lock()
+	set, in := gifFrameSets.sets[fileName]
+	if !in {
+		set = new(gifFrameSet)
+		gifFrameSets.sets[fileName] = set
+	}
+	gifFrameSets.Unlock()
+	set.once.Do(func() { set.frames, set.err = decodeGifFrames(fileName) })
+	return set.frames, set.err
+}
+
+// Discards the frames of every animated GIF decoded so far, so that the next run decodes
+// them afresh.
+func releaseGifFrames() {
+	gifFrameSets.Lock()
+	defer gifFrameSets.Unlock()
+	gifFrameSets.sets = map[string]*gifFrameSet{}
+}
+
+// Gets one composited frame of an animated GIF. As compositing a frame requires compositing
+// all those before it, all the frames of a GIF are composited together the first time any of
+// them is needed, and kept until the run ends.
+func gifFrameImage(name string) (img image.Image, err error) {
+	fileName, frame, _ := SplitFrameName(name)
+	frames, err := gifFramesOf(fileName)
+	if err != nil {
+		return
+	}
+	if frame >= len(frames) {
+		err = fmt.Errorf("%s: GIF has only %d frames", name, len(frames))
+		return
+	}
+	img = frames[frame]
+	return
+}
+
+// Skips a sequence of GIF data sub-blocks, up to and including the empty one that ends it.
+func skipGifSubBlocks(br *bufio.Reader) error {
+	for {
+		size, err := br.ReadByte()
+		if err != nil {
+			return err
+		}
+		if size == 0 {
+			return nil
+		}
+		if _, err = br.Discard(int(size)); err != nil {
+			return err
+		}
+	}
+}
+
+// Counts the frames of a GIF by walking its blocks, without decoding any of its image data.
+func countGifFrames(r io.Reader) (frames int, err error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, 13)
+	if _, err = io.ReadFull(br, header); err != nil {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if string(header[:3]) != "GIF" {
+		return 0, image.ErrFormat
+	}
+	if header[10]&0x80 != 0 {
+		if _, err = br.Discard(3 << (header[10]&7 + 1)); err != nil {
+			return 0, io.ErrUnexpectedEOF
+		}
+	}
+	for {
+		introducer, errR := br.ReadByte()
+		if errR != nil {
+			return frames, io.ErrUnexpectedEOF
+		}
+		switch introducer {
+		case 0x21: // An extension: a label, then sub-blocks.
+			if _, err = br.ReadByte(); err == nil {
+				err = skipGifSubBlocks(br)
+			}
+		case 0x2C: // An image: a descriptor, a local colour table, the LZW code size, then sub-blocks.
+			descriptor := make([]byte, 9)
+			if _, err = io.ReadFull(br, descriptor); err != nil {
+				break
+			}
+			if descriptor[8]&0x80 != 0 {
+				if _, err = br.Discard(3 << (descriptor[8]&7 + 1)); err != nil {
+					break
+				}
+			}
+			if _, err = br.ReadByte(); err == nil {
+				err = skipGifSubBlocks(br)
+			}
+			frames++
+		case 0x3B: // The trailer.
+			return frames, nil
+		default:
+			return frames, fmt.Errorf("gif: unknown block type: 0x%.2x", introducer)
+		}
+		if err != nil {
+			return frames, io.ErrUnexpectedEOF
+		}
+	}
+}
+
+// Replaces each animated GIF among the input files with every 'step'th frame of it.
+// Other input files are passed through unchanged. If 'failures' is given, a GIF that
+// cannot be read is recorded there and left out, rather than returning an error.
+func expandGifFrames(files []string, step int, failures *ReadFailureLog) (rv []string, err error) {
+	for _, file := range files {
+		if _, _, isFrame := SplitFrameName(file); isFrame || !strings.EqualFold(filepath.Ext(file), ".gif") {
+			rv = append(rv, file)
+			continue
+		}
+		reader, closer, errO := openImageSource(file)
+		if errO != nil && failures != nil {
+			failures.Record(file, errO)
+			continue
+		} else if errO != nil {
+			return rv, errO
+		}
+		frames, errD := countGifFrames(reader)
+		if errD != nil {
+			errD = imageDecodeError(file, reader, errD)
+		}
+		closer()
+		if errD != nil && failures != nil {
+			failures.Record(file, errD)
+			continue
+		} else if errD != nil {
+			return rv, errD
+		}
+		if frames <= 1 {
+			rv = append(rv, file)
+			continue
+		}
+		for frame := 0; frame < frames; frame += step {
+			rv = append(rv, gifFrameName(file, frame))
+		}
+	}
+	return
+}
+
+// Writes a composited frame of an animated GIF, named in the form 'file.gif#frameN', as a
+// PNG file into the directory 'dir'. Returns the pathname of the PNG file.
+func ExtractGifFrame(name, dir string) (extracted string, err error) {
+	fileName, frame, isFrame := SplitFrameName(name)
+	if !isFrame {
+		return "", errors.New("not a frame of an animated GIF: " + name)
+	}
+	archive, entry, inArchive := SplitArchiveName(fileName)
+	base, source := filepath.Base(fileName), fileName
+	if inArchive {
+		base, source = filepath.Base(archive)+"_"+path.Base(entry), archive
+	}
+	// GIFs of the same name in different directories are told apart by a hash of the path.
+	if abs, errA := filepath.Abs(source); errA == nil {
+		fileName = abs + strings.TrimPrefix(fileName, source)
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(fileName))
+	extracted = filepath.Join(dir, fmt.Sprintf("%s_%08x_frame%d.png", strings.TrimSuffix(base, filepath.Ext(base)), hash.Sum32(), frame))
+	img, err := gifFrameImage(name)
+	if err != nil {
+		return
+	}
+	var encoded bytes.Buffer
+	if err = png.Encode(&encoded, img); err != nil {
+		return
+	}
+	// A file left by an earlier run is reused only if it holds the same contents.
+	if existing, errE := os.ReadFile(extracted); errE == nil && bytes.Equal(existing, encoded.Bytes()) {
+		return
+	}
+	if err = os.MkdirAll(dir, 0777); err != nil {
+		return
+	}
+	err = os.WriteFile(extracted, encoded.Bytes(), 0666)
+	return
+}
@@ -0,0 +1,112 @@
+package CollageCreator
+
+import (
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Writes an animated GIF of 'frames' frames, each a different shade of grey, to 'fileName'.
+func writeTestGif(t *testing.T, fileName string, frames int) {
+	g := &gif.GIF{}
+	for i := 0; i < frames; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, 8, 8), palette.Plan9)
+		for j := range frame.Pix {
+			frame.Pix[j] = uint8(frame.Palette.Index(color.Gray{uint8(40 * i)}))
+		}
+		g.Image = append(g.Image, frame)
+		g.Delay = append(g.Delay, 10)
+	}
+	if err := os.MkdirAll(filepath.Dir(fileName), 0777); err != nil {
+		t.Fatal(err)
+	}
+	fp, err := os.Create(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fp.Close()
+	if err := gif.EncodeAll(fp, g); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCountGifFrames(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "clip.gif")
+	writeTestGif(t, fileName, 3)
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frames, err := countGifFrames(strings.NewReader(string(data))); err != nil || frames != 3 {
+		t.Errorf("counted %d frames, %v; want 3", frames, err)
+	}
+	if _, err := countGifFrames(strings.NewReader(string(data[:len(data)-20]))); err == nil {
+		t.Error("counting the frames of a truncated GIF did not fail")
+	}
+	if _, err := countGifFrames(strings.NewReader("\x89PNG\r\n\x1a\n\x00\x00\x00\x00\x00")); err != image.ErrFormat {
+		t.Errorf("counting the frames of a PNG gave %v, want %v", err, image.ErrFormat)
+	}
+
+	expanded, err := expandGifFrames([]string{fileName}, 2, nil)
+	if want := []string{gifFrameName(fileName, 0), gifFrameName(fileName, 2)}; err != nil || !reflect.DeepEqual(expanded, want) {
+		t.Errorf("expanded to %v, %v; want %v", expanded, err, want)
+	}
+}
+
+func TestGifFramesDecodedOnce(t *testing.T) {
+	defer releaseGifFrames()
+	fileName := filepath.Join(t.TempDir(), "clip.gif")
+	writeTestGif(t, fileName, 3)
+	if _, err := gifFrameImage(gifFrameName(fileName, 0)); err != nil {
+		t.Fatal(err)
+	}
+	// The other frames were composited along with the first, so the file is not read again.
+	if err := os.Remove(fileName); err != nil {
+		t.Fatal(err)
+	}
+	img, err := gifFrameImage(gifFrameName(fileName, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := color.NRGBAModel.Convert(img.At(0, 0)), color.NRGBAModel.Convert(color.Palette(palette.Plan9).Convert(color.Gray{80})); got != want {
+		t.Errorf("frame 2 is %v, want %v", got, want)
+	}
+	if _, err := gifFrameImage(gifFrameName(fileName, 3)); err == nil {
+		t.Error("getting a frame past the last did not fail")
+	}
+}
+
+func TestExtractGifFrame(t *testing.T) {
+	defer releaseGifFrames()
+	dir := t.TempDir()
+	first, second := filepath.Join(dir, "a", "clip.gif"), filepath.Join(dir, "b", "clip.gif")
+	writeTestGif(t, first, 2)
+	writeTestGif(t, second, 3)
+	extractDir := filepath.Join(dir, "extracted")
+	extractedFirst, err := ExtractGifFrame(gifFrameName(first, 1), extractDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	extractedSecond, err := ExtractGifFrame(gifFrameName(second, 1), extractDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if extractedFirst == extractedSecond {
+		t.Fatalf("frames of a/clip.gif and b/clip.gif were both extracted to %s", extractedFirst)
+	}
+	if err := os.WriteFile(extractedFirst, []byte("stale"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ExtractGifFrame(gifFrameName(first, 1), extractDir); err != nil {
+		t.Fatal(err)
+	}
+	if contents, _ := os.ReadFile(extractedFirst); string(contents) == "stale" {
+		t.Error("a stale extracted frame was kept")
+	}
+}
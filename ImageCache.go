@@ -0,0 +1,196 @@
+// This file contains a memory-bounded cache of decoded images, and auxiliary
+// methods that decode an image at a reduced size when only a fraction of its
+// native size is needed. Go's image decoders cannot decode at a reduced scale,
+// so a reduced image is decoded in full and downsampled at once; only the
+// reduced copy is kept. So that the full-size images do not add up to more
+// memory than the cache itself may hold, no more of them are decoded at once
+// than fit in its capacity.
+package CollageCreator
+
+import (
+	"container/list"
+	"image"
+	"math"
+	"sync"
+
+	"github.com/nfnt/resize"
+)
+
+// The default capacity of the cache of decoded images kept in 'Parameters', in bytes.
+const imageCache_DefaultCapacity int64 = 256 << 20
+
+// Implemented by an 'ImageInfo' that can supply its image data at a reduced size.
+type ReducibleImageInfo interface {
+	ImageInfo
+	// Gets the image data, reduced if possible to a size no smaller in either
+	// dimension than 'size'.
+	ImageDataAtSize(size Dims) interface{}
+}
+
+// Gets the data of an image, reduced if possible to a size no smaller in either
+// dimension than 'size'.
+func ImageDataAtSize(info ImageInfo, size Dims) interface{} {
+	if reducible, ok := info.(ReducibleImageInfo); ok {
+		return reducible.ImageDataAtSize(size)
+	}
+	return info.ImageData()
+}
+
+// Calculates the largest power of two by which an image of the given size may be
+// reduced while remaining at least 'size' in either dimension.
+func reductionFactor(fullSize, size Dims) int {
+	factor := 1
+	if size.X() <= 0 || size.Y() <= 0 || math.IsNaN(size.X()) || math.IsNaN(size.Y()) {
+		return factor
+	}
+	for fullSize.X()/float64(2*factor) >= size.X() && fullSize.Y()/float64(2*factor) >= size.Y() {
+		factor *= 2
+	}
+	return factor
+}
+
+// Downsamples an image by the given factor.
+func reduceImage(img image.Image, factor int) image.Image {
+	if factor <= 1 {
+		return img
+	}
+	bounds := img.Bounds()
+	return resize.Resize(uint(bounds.Dx()/factor), uint(bounds.Dy()/factor), img, resize.Bilinear)
+}
+
+// Estimates the memory held by a decoded image.
+func imageBytes(img image.Image) int64 {
+	bytesPerPixel := int64(4)
+	switch img.(type) {
+	case *image.Gray, *image.Alpha, *image.Paletted:
+		bytesPerPixel = 1
+	case *image.Gray16, *image.Alpha16:
+		bytesPerPixel = 2
+	case *image.YCbCr:
+		bytesPerPixel = 3
+	case *image.RGBA64, *image.NRGBA64:
+		bytesPerPixel = 8
+	}
+	return int64(img.Bounds().Dx()) * int64(img.Bounds().Dy()) * bytesPerPixel
+}
+
+type imageCache_key struct {
+	fileName string
+	factor   int
+}
+
+type imageCache_entry struct {
+	key   imageCache_key
+	img   image.Image
+	bytes int64
+}
+
+// A cache of decoded images, holding at most a given number of bytes of pixel data
+// and discarding the least recently used images first. It is safe for concurrent use.
+type ImageCache struct {
+	mutex    sync.Mutex
+	capacity int64
+	used     int64
+	order    *list.List
+	entries  map[imageCache_key]*list.Element
+	// The estimated bytes of the full-size images being decoded, and a signal that one is done.
+	decoding     int64
+	decodingDone *sync.Cond
+}
+
+// Creates an image cache that holds at most 'capacity' bytes.
+func NewImageCache(capacity int64) *ImageCache {
+	ic := &ImageCache{capacity: capacity, order: list.New(), entries: map[imageCache_key]*list.Element{}}
+	ic.decodingDone = sync.NewCond(&ic.mutex)
+	return ic
+}
+
+// Changes the number of bytes the cache may hold, discarding images as necessary.
+func (ic *ImageCache) SetCapacity(capacity int64) {
+	ic.mutex.Lock()
+	defer ic.mutex.Unlock()
+	ic.capacity = capacity
+	ic.evict()
+}
+
+func (ic *ImageCache) evict() {
+	for ic.used > ic.capacity && ic.order.Len() > 0 {
+		entry := ic.order.Remove(ic.order.Back()).(imageCache_entry)
+		delete(ic.entries, entry.key)
+		ic.used -= entry.bytes
+	}
+}
+
+// Gets the image stored under the given file name and reduction factor, if any.
+func (ic *ImageCache) get(fileName string, factor int) (img image.Image, found bool) {
+	ic.mutex.Lock()
+	defer ic.mutex.Unlock()
+	if elem, in := ic.entries[imageCache_key{fileName, factor}]; in {
+		ic.order.MoveToFront(elem)
+		return elem.Value.(imageCache_entry).img, true
+	}
+	return
+}
+
+// Stores an image under the given file name and reduction factor, unless it alone
+// would exceed the capacity of the cache.
+func (ic *ImageCache) put(fileName string, factor int, img image.Image) {
+	ic.mutex.Lock()
+	defer ic.mutex.Unlock()
+	key := imageCache_key{fileName, factor}
+	bytes := imageBytes(img)
+	if bytes > ic.capacity {
+		return
+	}
+	if elem, in := ic.entries[key]; in {
+		ic.used -= elem.Value.(imageCache_entry).bytes
+		ic.order.Remove(elem)
+	}
+	ic.entries[key] = ic.order.PushFront(imageCache_entry{key, img, bytes})
+	ic.used += bytes
+	ic.evict()
+}
+
+// Waits until a full-size image of the given estimated bytes can be decoded without those
+// being decoded at once exceeding the capacity of the cache, then counts it as being decoded.
+// An image larger than the capacity is decoded alone.
+func (ic *ImageCache) startDecoding(bytes int64) {
+	ic.mutex.Lock()
+	defer ic.mutex.Unlock()
+	for ic.decoding > 0 && ic.decoding+bytes > ic.capacity {
+		ic.decodingDone.Wait()
+	}
+	ic.decoding += bytes
+}
+
+// Counts a full-size image started with 'startDecoding' as no longer being decoded.
+func (ic *ImageCache) finishDecoding(bytes int64) {
+	ic.mutex.Lock()
+	defer ic.mutex.Unlock()
+	ic.decoding -= bytes
+	ic.decodingDone.Broadcast()
+}
+
+// Gets an image of the given full size, reduced by the given factor, from the cache or,
+// failing that, by decoding it with 'decode' and reducing it, then storing the result in the
+// cache. Without a cache, the image is decoded and reduced every time.
+func (ic *ImageCache) fetch(fileName string, factor int, fullSize Dims, decode func() image.Image) image.Image {
+	if ic == nil {
+		return reduceImage(decode(), factor)
+	}
+	if img, found := ic.get(fileName, factor); found {
+		return img
+	}
+	var img image.Image
+	if full, found := ic.get(fileName, 1); found {
+		img = reduceImage(full, factor)
+	} else {
+		// Decoders produce at most 4 bytes per pixel for images of 8 bits per channel.
+		bytes := int64(fullSize.X()) * int64(fullSize.Y()) * 4
+		ic.startDecoding(bytes)
+		img = reduceImage(decode(), factor)
+		ic.finishDecoding(bytes)
+	}
+	ic.put(fileName, factor, img)
+	return img
+}
@@ -0,0 +1,60 @@
+package CollageCreator
+
+import (
+	"image"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestImageCacheBoundsConcurrentDecodes(t *testing.T) {
+	// Two 10x10 images take 400 bytes each to decode; only one fits in the capacity at once.
+	cache := NewImageCache(600)
+	var decoding, most int32
+	decode := func() image.Image {
+		now := atomic.AddInt32(&decoding, 1)
+		for {
+			seen := atomic.LoadInt32(&most)
+			if now <= seen || atomic.CompareAndSwapInt32(&most, seen, now) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		atomic.AddInt32(&decoding, -1)
+		return image.NewGray(image.Rect(0, 0, 10, 10))
+	}
+	done := make(chan bool)
+	for _, name := range []string{"a.png", "b.png", "c.png"} {
+		go func(name string) {
+			cache.fetch(name, 2, NewDims(10, 10), decode)
+			done <- true
+		}(name)
+	}
+	for i := 0; i < 3; i++ {
+		<-done
+	}
+	if most != 1 {
+		t.Errorf("%d images were decoded at once, want 1", most)
+	}
+
+	// An image larger than the capacity is still decoded, alone.
+	if img := cache.fetch("large.png", 1, NewDims(100, 100), func() image.Image { return image.NewGray(image.Rect(0, 0, 100, 100)) }); img == nil {
+		t.Error("an image larger than the cache was not decoded")
+	}
+}
+
+func TestImageCacheIsPerParameters(t *testing.T) {
+	first, second := newTestParameters(), newTestParameters()
+	if first.ImageCache() == second.ImageCache() {
+		t.Fatal("two sets of parameters share an image cache")
+	}
+	first.SetOther(Raster_CacheSize, 1)
+	first.SetOther(Raster_PreloadImages, false)
+	first.SetInFiles([]string{})
+	if _, err := readInputImages_Raster(first); err != nil {
+		t.Fatal(err)
+	}
+	if first.ImageCache().capacity != 1<<20 || second.ImageCache().capacity != imageCache_DefaultCapacity {
+		t.Errorf("capacities %d and %d, want -cache-mb to apply only to its own parameters", first.ImageCache().capacity, second.ImageCache().capacity)
+	}
+}
@@ -59,6 +59,11 @@ type ImageLayout interface {
 	SetScaling(img ImageIdentifier, geom Geometry) (rv ImageLayout, collidedWith *ImageIdentifier)
 	// Tests whether an image, positioned in the ImageLayout, collides with any others.
 	TestCollision(newImage ImageIdentifier) *ImageIdentifier
+	// Gets the importance weight of the given image (1 unless otherwise set).
+	WeightOf(img ImageIdentifier) float64
+	// Sets the importance weight of the given image. Returns an ImageLayout including the new weight
+	// (which may or may not be the same object as the input ImageLayout).
+	SetWeight(img ImageIdentifier, weight float64) ImageLayout
 }
 
 type ImageLayout_impl struct {
@@ -74,6 +79,7 @@ type imageLayout_data struct {
 	scaling    map[ImageIdentifier]Geometry
 	cropping   map[ImageIdentifier]Geometry
 	positions  map[ImageIdentifier]Dims
+	weights    map[ImageIdentifier]float64
 }
 
 // Creates a "nil" image layout object (used to indicate an error).
@@ -108,6 +114,10 @@ func (iLay ImageLayout_impl) Duplicate() ImageLayout {
 	for img, info := range iLay.data.positions {
 		rv.data.positions[img] = info
 	}
+	rv.data.weights = map[ImageIdentifier]float64{}
+	for img, info := range iLay.data.weights {
+		rv.data.weights[img] = info
+	}
 	return rv
 }
 
@@ -217,9 +227,106 @@ func (iLay ImageLayout_impl) SetCropping(img ImageIdentifier, geom Geometry) (rv
 	return
 }
 
+func (iLay ImageLayout_impl) WeightOf(img ImageIdentifier) float64 {
+	if weight, in := iLay.data.weights[img]; in {
+		return weight
+	}
+	return 1
+}
+
+func (iLay ImageLayout_impl) SetWeight(img ImageIdentifier, weight float64) ImageLayout {
+	if iLay.data.weights == nil {
+		iLay.data.weights = map[ImageIdentifier]float64{}
+	}
+	iLay.data.weights[img] = weight
+	return iLay
+}
+
+// Replaces the 'ImageInfo' for the given image, as when an 'InputImageReader' adds
+// information to the images read by another.
+func setImageInfo(iLay ImageLayout, img ImageIdentifier, info ImageInfo) ImageLayout {
+	if impl, ok := iLay.(ImageLayout_impl); ok {
+		impl.data.imageInfo[img] = info
+	}
+	return iLay
+}
+
+// Creates a layout holding only the given images of another layout, with all the
+// information set for them there.
+func subsetImageLayout(iLay ImageLayout, images []ImageIdentifier) ImageLayout {
+	rv := ImageLayout_impl{data: new(imageLayout_data)}
+	rv.data.size = iLay.CanvasSize()
+	rv.data.parameters = iLay.Parameters()
+	rv.data.images = images
+	rv.data.imageInfo = make(map[ImageIdentifier]ImageInfo)
+	rv.data.dimensions = make(map[ImageIdentifier]Dims)
+	rv.data.cropping = make(map[ImageIdentifier]Geometry)
+	rv.data.scaling = make(map[ImageIdentifier]Geometry)
+	rv.data.positions = make(map[ImageIdentifier]Dims)
+	rv.data.weights = make(map[ImageIdentifier]float64)
+	impl, isImpl := iLay.(ImageLayout_impl)
+	for _, img := range images {
+		rv.data.imageInfo[img] = iLay.ImageInfoOf(img)
+		rv.data.dimensions[img] = iLay.DimensionsOf(img)
+		rv.data.cropping[img] = iLay.CroppingOf(img)
+		rv.data.scaling[img] = iLay.ScalingOf(img)
+		rv.data.weights[img] = iLay.WeightOf(img)
+		if isImpl {
+			if position, in := impl.data.positions[img]; in {
+				rv.data.positions[img] = position
+			}
+		}
+	}
+	return rv
+}
+
+// Changes the scaling of the given image so that, as placed on the canvas, it has the
+// given dimensions, adjusting any cropping in proportion so that the same part of the
+// image is shown. Scaled sizes and crop offsets are passed through 'round'.
+func resizeImage(iLay ImageLayout, img ImageIdentifier, newDims Dims, round func(float64) float64) ImageLayout {
+	dims := iLay.DimensionsOf(img)
+	original := iLay.ImageInfoOf(img).DimensionsOf()
+	scaling := iLay.ScalingOf(img)
+	cropping := iLay.CroppingOf(img)
+	if cropping.HasOffset() {
+		scaled := scaling.Scale(original)
+		factor := NewDims(newDims.X()/dims.X(), newDims.Y()/dims.Y())
+		offset := cropping.Offset(scaled)
+		newOffset := NewDims(round(offset.X()*factor.X()), round(offset.Y()*factor.Y()))
+		newScaled := NewDims(
+			math.Max(round(scaled.X()*factor.X()), newOffset.X()+newDims.X()),
+			math.Max(round(scaled.Y()*factor.Y()), newOffset.Y()+newDims.Y()))
+		iLay, _ = iLay.SetCropping(img, NewCroppingGeometry(newDims, newOffset))
+		if scaling.HasSize() || newScaled != original {
+			iLay, _ = iLay.SetScaling(img, NewScalingGeometry(newScaled))
+		}
+	} else if scaling.HasSize() || newDims != original {
+		iLay, _ = iLay.SetScaling(img, NewScalingGeometry(newDims))
+	}
+	return iLay
+}
+
+// Crops the given image, as placed on the canvas, to the given dimensions, taking
+// the same amount from either side of each dimension.
+func cropImageCentered(iLay ImageLayout, img ImageIdentifier, newDims Dims) ImageLayout {
+	dims := iLay.DimensionsOf(img)
+	offset := NewDims((dims.X()-newDims.X())/2, (dims.Y()-newDims.Y())/2)
+	scaling, cropping := ComposeScaleAndCrop(iLay.ImageInfoOf(img).DimensionsOf(),
+		iLay.ScalingOf(img), iLay.CroppingOf(img),
+		EmptyGeometry(), NewCroppingGeometry(newDims, offset))
+	iLay.SetCropping(img, cropping)
+	iLay.SetScaling(img, scaling)
+	return iLay
+}
+
 // Calculates the padding to be maintained around the given image.
 func Padding(iLay ImageLayout, img ImageIdentifier) Dims {
-	return iLay.Parameters().Padding().Scale(iLay.DimensionsOf(img))
+	return paddingForDims(iLay.Parameters(), iLay.DimensionsOf(img))
+}
+
+// Calculates the padding to be maintained around an image of the given dimensions.
+func paddingForDims(parameters *Parameters, dims Dims) Dims {
+	return parameters.Padding().Scale(dims)
 }
 
 // Determines whether the padding to be maintained around the given
@@ -0,0 +1,285 @@
+package CollageCreator
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"math"
+	"math/bits"
+	"sort"
+	"strings"
+
+	"github.com/nfnt/resize"
+)
+
+const (
+	Dedup_Method    string = "Dedup_Method"
+	Dedup_Threshold string = "Dedup_Threshold"
+	Dedup_Keep      string = "Dedup_Keep"
+)
+
+// The perceptual hashes by which duplicate images may be found.
+type PerceptualHashMethod int
+
+const (
+	// A difference hash, comparing the brightness of horizontally adjacent pixels.
+	DHash PerceptualHashMethod = iota
+	// A DCT-based hash, comparing low-frequency components of the image with their median.
+	PHash
+)
+
+// The criteria by which the image kept out of a cluster of duplicates may be chosen.
+type DedupKeepCriterion int
+
+const (
+	// Keep the image with the most pixels.
+	KeepResolution DedupKeepCriterion = iota
+	// Keep the image with the most fine detail, as measured by the variance of its Laplacian.
+	KeepSharpness
+)
+
+// Converts an image to a grid of brightness values of the given size.
+func grayGrid(img image.Image, w, h int) [][]float64 {
+	small := resize.Resize(uint(w), uint(h), img, resize.Bilinear)
+	bounds := small.Bounds()
+	grid := make([][]float64, h)
+	for y := 0; y < h; y++ {
+		grid[y] = make([]float64, w)
+		for x := 0; x < w; x++ {
+			r, g, b, _ := small.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			grid[y][x] = 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+		}
+	}
+	return grid
+}
+
+// Computes a 64-bit difference hash of an image.
+func dHash(img image.Image) (hash uint64) {
+	grid := grayGrid(img, 9, 8)
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if grid[y][x] < grid[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return
+}
+
+// Computes a 64-bit DCT-based perceptual hash of an image.
+func pHash(img image.Image) (hash uint64) {
+	const n = 32
+	grid := grayGrid(img, n, n)
+	coefficients := make([]float64, 0, 64)
+	for v := 0; v < 8; v++ {
+		for u := 0; u < 8; u++ {
+			sum := 0.0
+			for y := 0; y < n; y++ {
+				for x := 0; x < n; x++ {
+					sum += grid[y][x] * math.Cos(float64(2*x+1)*float64(u)*math.Pi/(2*n)) * math.Cos(float64(2*y+1)*float64(v)*math.Pi/(2*n))
+				}
+			}
+			coefficients = append(coefficients, sum)
+		}
+	}
+	// The first coefficient, the average brightness, says nothing of the image's structure.
+	sorted := append([]float64{}, coefficients[1:]...)
+	sort.Float64s(sorted)
+	median := (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+	for _, c := range coefficients {
+		hash <<= 1
+		if c > median {
+			hash |= 1
+		}
+	}
+	return
+}
+
+// Measures the sharpness of an image as the variance of its Laplacian.
+func sharpness(img image.Image) float64 {
+	const n = 256
+	bounds := img.Bounds()
+	w, h := n, n
+	if bounds.Dx() > bounds.Dy() {
+		h = int(math.Max(3, math.Round(float64(n*bounds.Dy())/float64(bounds.Dx()))))
+	} else {
+		w = int(math.Max(3, math.Round(float64(n*bounds.Dx())/float64(bounds.Dy()))))
+	}
+	grid := grayGrid(img, w, h)
+	sum, sumSq, count := 0.0, 0.0, 0.0
+	for y := 1; y < h-1; y++ {
+		for x := 1; x < w-1; x++ {
+			l := grid[y-1][x] + grid[y+1][x] + grid[y][x-1] + grid[y][x+1] - 4*grid[y][x]
+			sum += l
+			sumSq += l * l
+			count++
+		}
+	}
+	if count == 0 {
+		return 0
+	}
+	mean := sum / count
+	return sumSq/count - mean*mean
+}
+
+// Groups the images of the given hashes into clusters of near-duplicates, in which every
+// two hashes lie within 'threshold' bits of each other. Each image joins the first cluster
+// it is near to all the members of, so that a chain of images, each near the next, does
+// not gather images far apart into one cluster. Unhashable images are clustered with
+// nothing. The clusters, and the images in each, are in the order of the images.
+func clusterHashes(hashes []uint64, unhashable []bool, threshold int) (clusters [][]int) {
+	for i := range hashes {
+		joined := false
+		for c := 0; !unhashable[i] && !joined && c < len(clusters); c++ {
+			near := !unhashable[clusters[c][0]]
+			for _, j := range clusters[c] {
+				near = near && bits.OnesCount64(hashes[i]^hashes[j]) <= threshold
+			}
+			if near {
+				clusters[c] = append(clusters[c], i)
+				joined = true
+			}
+		}
+		if !joined {
+			clusters = append(clusters, []int{i})
+		}
+	}
+	return
+}
+
+// Removes duplicate and near-duplicate images from a layout: images whose perceptual hashes all
+// lie within 'threshold' bits of each other are clustered, and one image from each cluster is kept.
+// Each image dropped is reported along with the one kept in its place.
+func RemoveDuplicateImages(imageLayout ImageLayout, method PerceptualHashMethod, threshold int, keep DedupKeepCriterion) ImageLayout {
+	parameters := imageLayout.Parameters()
+	images := imageLayout.Images(true)
+	hashes := make([]uint64, len(images))
+	unhashable := make([]bool, len(images))
+	for i, img := range images {
+		imgData, ok := ImageDataAtSize(imageLayout.ImageInfoOf(img), NewDims(64, 64)).(image.Image)
+		if !ok {
+			// Not a raster image; it is kept, and clustered with nothing.
+			unhashable[i] = true
+			continue
+		}
+		switch method {
+		case PHash:
+			hashes[i] = pHash(imgData)
+		default:
+			hashes[i] = dHash(imgData)
+		}
+	}
+	score := func(i int) float64 {
+		info := imageLayout.ImageInfoOf(images[i])
+		if keep == KeepSharpness {
+			if imgData, ok := ImageDataAtSize(info, NewDims(256, 256)).(image.Image); ok {
+				return sharpness(imgData)
+			}
+		}
+		return info.DimensionsOf().X() * info.DimensionsOf().Y()
+	}
+	kept := make([]bool, len(images))
+	// Clusters are visited in the order of their first images, so that the images dropped
+	// are reported in the same order on every run.
+	for _, members := range clusterHashes(hashes, unhashable, threshold) {
+		best, bestScore := members[0], math.Inf(-1)
+		if len(members) > 1 {
+			for _, i := range members {
+				if s := score(i); s > bestScore {
+					best, bestScore = i, s
+				}
+			}
+		}
+		kept[best] = true
+		for _, i := range members {
+			if i != best {
+				parameters.ProgressMonitor().ReportMessage(fmt.Sprintf("Dropping %s: duplicate of %s (hash distance %d)",
+					imageLayout.ImageInfoOf(images[i]).FileName(), imageLayout.ImageInfoOf(images[best]).FileName(), bits.OnesCount64(hashes[i]^hashes[best])))
+			}
+		}
+	}
+	keptImages := []ImageIdentifier{}
+	for i, img := range images {
+		if kept[i] {
+			keptImages = append(keptImages, img)
+		}
+	}
+	return subsetImageLayout(imageLayout, keptImages)
+}
+
+type InputImageReader_Dedup_CustomParameters struct {
+	method    string
+	threshold int
+	keep      string
+}
+
+func InputImageReader_Dedup_Init(inner InputImageReader) InputImageReader_Dedup {
+	return InputImageReader_Dedup{new(InputImageReader_Dedup_CustomParameters), inner}
+}
+
+// An InputImageReader that reads images with another InputImageReader, then removes duplicates
+// and near-duplicates (such as shots from a burst) among them, as found by a perceptual hash.
+type InputImageReader_Dedup struct {
+	p     *InputImageReader_Dedup_CustomParameters
+	inner InputImageReader
+}
+
+func (iid InputImageReader_Dedup) RegisterCustomParameters(parameters *Parameters) bool {
+	flag.StringVar(&(iid.p.method), "dedup", "", "Remove near-duplicate images, as found by a perceptual hash: 'dhash' or 'phash'")
+	flag.IntVar(&(iid.p.threshold), "dedup-threshold", 8, "Number of bits (of 64) by which the hashes of near-duplicate images may differ")
+	flag.StringVar(&(iid.p.keep), "dedup-keep", "resolution", "Which of a set of near-duplicate images to keep: 'resolution' or 'sharpness'")
+	return iid.inner.RegisterCustomParameters(parameters)
+}
+
+func (iid InputImageReader_Dedup) ParseCustomParameters(parameters *Parameters) bool {
+	switch strings.ToLower(iid.p.method) {
+	case "":
+	case "dhash":
+		parameters.SetOther(Dedup_Method, DHash)
+	case "phash":
+		parameters.SetOther(Dedup_Method, PHash)
+	default:
+		parameters.ProgressMonitor().ReportMessage("-dedup value must be 'dhash' or 'phash'")
+		return false
+	}
+	if iid.p.threshold < 0 || iid.p.threshold > 64 {
+		parameters.ProgressMonitor().ReportMessage("-dedup-threshold value must be between 0 and 64")
+		return false
+	}
+	parameters.SetOther(Dedup_Threshold, iid.p.threshold)
+	switch strings.ToLower(iid.p.keep) {
+	case "resolution":
+		parameters.SetOther(Dedup_Keep, KeepResolution)
+	case "sharpness":
+		parameters.SetOther(Dedup_Keep, KeepSharpness)
+	default:
+		parameters.ProgressMonitor().ReportMessage("-dedup-keep value must be 'resolution' or 'sharpness'")
+		return false
+	}
+	return iid.inner.ParseCustomParameters(parameters)
+}
+
+func (iid InputImageReader_Dedup) ReadInputImages(parameters *Parameters) (il ImageLayout, err error) {
+	if il, err = iid.inner.ReadInputImages(parameters); err != nil {
+		return
+	}
+	methodI, valid := parameters.Other(Dedup_Method)
+	if !valid {
+		return
+	}
+	method, valid := methodI.(PerceptualHashMethod)
+	if !valid {
+		il, err = CreateNilImageLayout(), errors.New("mistyped perceptual hash method")
+		return
+	}
+	keep := KeepResolution
+	if keepI, valid := parameters.Other(Dedup_Keep); valid {
+		keep, _ = keepI.(DedupKeepCriterion)
+	}
+	before := il.TotalImageCount()
+	il = RemoveDuplicateImages(il, method, parameters.OtherInt(Dedup_Threshold), keep)
+	parameters.ProgressMonitor().ReportMessage(fmt.Sprintf("Removed %d duplicate images, leaving %d", before-il.TotalImageCount(), il.TotalImageCount()))
+	return
+}
@@ -0,0 +1,77 @@
+package CollageCreator
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math/bits"
+	"reflect"
+	"testing"
+)
+
+// Creates a grey image with a vertical bar at 'bar' tenths of its width.
+func barImage(bar int) image.Image {
+	img := image.NewGray(image.Rect(0, 0, 100, 80))
+	for y := 0; y < 80; y++ {
+		for x := 0; x < 100; x++ {
+			img.SetGray(x, y, color.Gray{128})
+			if x/10 == bar {
+				img.SetGray(x, y, color.Gray{255})
+			}
+		}
+	}
+	return img
+}
+
+func TestRemoveDuplicateImages(t *testing.T) {
+	var reports [][]string
+	for run := 0; run < 5; run++ {
+		var messages []string
+		parameters := newRecordingTestParameters(&messages)
+		infos := []ImageInfo{}
+		for i := 0; i < 4; i++ {
+			infos = append(infos, ImageInfo_vector{id: ImageIdentifier(len(infos)), fileName: fmt.Sprintf("drawing%d.svg", i), dims: NewDims(100, 80)})
+		}
+		for i, bar := range []int{2, 2, 7, 7, 2} {
+			infos = append(infos, NewImageInfoFromImage(ImageIdentifier(len(infos)), fmt.Sprintf("photo%d", i), barImage(bar)))
+		}
+		il := RemoveDuplicateImages(createImageLayout(parameters, infos), DHash, 8, KeepResolution)
+		kept := map[string]bool{}
+		for _, img := range il.Images(false) {
+			kept[il.ImageInfoOf(img).FileName()] = true
+		}
+		for i := 0; i < 4; i++ {
+			if name := fmt.Sprintf("drawing%d.svg", i); !kept[name] {
+				t.Errorf("vector image %s was dropped", name)
+			}
+		}
+		if len(kept) != 6 {
+			t.Errorf("kept %d images, want 6: %v", len(kept), kept)
+		}
+		reports = append(reports, messages)
+	}
+	for _, report := range reports[1:] {
+		if !reflect.DeepEqual(report, reports[0]) {
+			t.Errorf("dropped images reported as %v, then as %v", reports[0], report)
+		}
+	}
+}
+
+func TestClusterHashesDoesNotChain(t *testing.T) {
+	// Each hash lies 4 bits from the next, but the first and last lie 8 bits apart.
+	hashes := []uint64{0x00, 0x0F, 0xFF, 0x0F0F}
+	unhashable := []bool{false, false, false, true}
+	clusters := clusterHashes(hashes, unhashable, 4)
+	if want := [][]int{{0, 1}, {2}, {3}}; !reflect.DeepEqual(clusters, want) {
+		t.Errorf("clustered %v, want %v", clusters, want)
+	}
+	for _, cluster := range clusters {
+		for _, i := range cluster {
+			for _, j := range cluster {
+				if d := bits.OnesCount64(hashes[i] ^ hashes[j]); d > 4 {
+					t.Errorf("images %d and %d clustered %d bits apart", i, j, d)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package CollageCreator
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	Expand_Recursive   string = "Expand_Recursive"
+	Expand_Extensions  string = "Expand_Extensions"
+	Expand_MinSize     string = "Expand_MinSize"
+	Expand_Include     string = "Expand_Include"
+	Expand_Exclude     string = "Expand_Exclude"
+	Expand_Order       string = "Expand_Order"
+	Expand_ShuffleSeed string = "Expand_ShuffleSeed"
+)
+
+// The extensions of the files taken from a directory or glob pattern when none are specified.
+const expand_DefaultExtensions string = "jpg,jpeg,png,gif,bmp,tif,tiff,webp"
+
+// Compares two strings in "natural" order, in which runs of digits are compared by numeric
+// value (so that 'img2.jpg' comes before 'img10.jpg').
+func NaturalLess(lhs, rhs string) bool {
+	l, r := []rune(lhs), []rune(rhs)
+	i, j := 0, 0
+	for i < len(l) && j < len(r) {
+		if unicode.IsDigit(l[i]) && unicode.IsDigit(r[j]) {
+			si, sj := i, j
+			for i < len(l) && unicode.IsDigit(l[i]) {
+				i++
+			}
+			for j < len(r) && unicode.IsDigit(r[j]) {
+				j++
+			}
+			ln := strings.TrimLeft(string(l[si:i]), "0")
+			rn := strings.TrimLeft(string(r[sj:j]), "0")
+			if len(ln) != len(rn) {
+				return len(ln) < len(rn)
+			} else if ln != rn {
+				return ln < rn
+			}
+			continue
+		}
+		if l[i] != r[j] {
+			return l[i] < r[j]
+		}
+		i++
+		j++
+	}
+	return len(l)-i < len(r)-j
+}
+
+type inputExpansion struct {
+	recursive  bool
+	extensions map[string]bool
+	include    []string
+	exclude    []string
+}
+
+func splitList(arg string) []string {
+	rv := []string{}
+	for _, item := range strings.Split(arg, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			rv = append(rv, item)
+		}
+	}
+	return rv
+}
+
+func matchesAny(patterns []string, fileName string) bool {
+	for _, pattern := range patterns {
+		matchedBase, _ := filepath.Match(pattern, filepath.Base(fileName))
+		matchedFull, _ := filepath.Match(pattern, fileName)
+		if matchedBase || matchedFull {
+			return true
+		}
+	}
+	return false
+}
+
+func (ie inputExpansion) hasImageExtension(fileName string) bool {
+	return ie.extensions[strings.ToLower(strings.TrimPrefix(filepath.Ext(fileName), "."))]
+}
+
+func (ie inputExpansion) expandDirectory(dir string, rv []string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return rv, err
+	}
+	sort.Slice(entries, func(i, j int) bool { return NaturalLess(entries[i].Name(), entries[j].Name()) })
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if entry.IsDir() {
+			if ie.recursive {
+				if rv, err = ie.expandDirectory(path, rv); err != nil {
+					return rv, err
+				}
+			}
+		} else if ie.hasImageExtension(path) {
+			rv = append(rv, path)
+		}
+	}
+	return rv, nil
+}
+
+func (ie inputExpansion) expandListFile(listFile string, rv []string) ([]string, error) {
+	fp, err := os.Open(listFile)
+	if err != nil {
+		return rv, err
+	}
+	defer fp.Close()
+	scanner := bufio.NewScanner(fp)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !filepath.IsAbs(line) {
+			line = filepath.Join(filepath.Dir(listFile), line)
+		}
+		if rv, err = ie.expandArgument(line, rv, false); err != nil {
+			return rv, err
+		}
+	}
+	return rv, scanner.Err()
+}
+
+func (ie inputExpansion) expandArgument(arg string, rv []string, allowListFile bool) ([]string, error) {
+	if allowListFile && strings.HasPrefix(arg, "@") {
+		return ie.expandListFile(arg[1:], rv)
+	}
+	if _, _, inArchive := SplitArchiveName(arg); inArchive {
+		return append(rv, arg), nil
+	}
+	if info, err := os.Stat(arg); err == nil {
+		if info.IsDir() {
+			return ie.expandDirectory(arg, rv)
+		}
+		return append(rv, arg), nil
+	}
+	if strings.ContainsAny(arg, "*?[") {
+		matches, err := filepath.Glob(arg)
+		if err != nil {
+			return rv, err
+		}
+		sort.Slice(matches, func(i, j int) bool { return NaturalLess(matches[i], matches[j]) })
+		for _, match := range matches {
+			if info, err := os.Stat(match); err == nil && info.IsDir() {
+				if rv, err = ie.expandDirectory(match, rv); err != nil {
+					return rv, err
+				}
+			} else if ie.hasImageExtension(match) {
+				rv = append(rv, match)
+			}
+		}
+		return rv, nil
+	}
+	return rv, errors.New("no such file, directory, or pattern: " + arg)
+}
+
+// Sorts a list of files by the given key: 'name', 'mtime', 'exif-date', 'size', or 'shuffle'.
+// Files for which the key cannot be read sort last, in name order.
+func orderInputFiles(files []string, order string, seed int64) error {
+	switch order {
+	case "":
+		return nil
+	case "name":
+		sort.SliceStable(files, func(i, j int) bool { return NaturalLess(files[i], files[j]) })
+		return nil
+	case "shuffle":
+		rand.New(rand.NewSource(seed)).Shuffle(len(files), func(i, j int) { files[i], files[j] = files[j], files[i] })
+		return nil
+	case "mtime", "size", "exif-date":
+	default:
+		return errors.New("-order value must be 'name', 'mtime', 'exif-date', 'size', or 'shuffle'")
+	}
+	keys := map[string]int64{}
+	for _, file := range files {
+		if order == "exif-date" {
+			fp, err := os.Open(file)
+			if err != nil {
+				continue
+			}
+			if t, valid := ReadExifCaptureTime(fp); valid {
+				keys[file] = t.UnixNano()
+			}
+			fp.Close()
+		} else if info, err := os.Stat(file); err == nil {
+			if order == "mtime" {
+				keys[file] = info.ModTime().UnixNano()
+			} else {
+				keys[file] = info.Size()
+			}
+		}
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		ki, ini := keys[files[i]]
+		kj, inj := keys[files[j]]
+		if ini != inj {
+			return ini
+		} else if ki != kj {
+			return ki < kj
+		}
+		return NaturalLess(files[i], files[j])
+	})
+	return nil
+}
+
+// Expands the input files given in 'parameters', which may include directories, glob patterns,
+// and '@'-prefixed files listing further inputs one per line, into a filtered and ordered list
+// of image files.
+func ExpandInputFiles(parameters *Parameters) (files []string, err error) {
+	ie := inputExpansion{recursive: parameters.OtherBool(Expand_Recursive), extensions: map[string]bool{}}
+	for _, ext := range splitList(parameters.OtherString(Expand_Extensions)) {
+		ie.extensions[strings.ToLower(strings.TrimPrefix(ext, "."))] = true
+	}
+	ie.include = splitList(parameters.OtherString(Expand_Include))
+	ie.exclude = splitList(parameters.OtherString(Expand_Exclude))
+	expanded := []string{}
+	for _, arg := range parameters.InFiles() {
+		if expanded, err = ie.expandArgument(arg, expanded, true); err != nil {
+			return
+		}
+	}
+	if expanded, err = expandArchives(expanded); err != nil {
+		return
+	}
+	minSize := parameters.OtherDims(Expand_MinSize)
+	failures := startReadFailureLog(parameters)
+	files = make([]string, 0, len(expanded))
+	for _, file := range expanded {
+		if (len(ie.include) > 0 && !matchesAny(ie.include, file)) || matchesAny(ie.exclude, file) {
+			continue
+		}
+		if minSize != NewDims(0, 0) {
+			info, _, errL := loadImage(0, file, false)
+			if errL != nil && failures != nil {
+				failures.Record(file, errL)
+				parameters.ProgressMonitor().ReportMessage(fmt.Sprintf("Skipping %s: %s", file, errL))
+				continue
+			} else if errL != nil {
+				err = errL
+				return
+			}
+			if dims := info.DimensionsOf(); dims.X() < minSize.X() || dims.Y() < minSize.Y() {
+				parameters.ProgressMonitor().ReportMessage(fmt.Sprintf("Skipping %s: smaller than the minimum size", file))
+				continue
+			}
+		}
+		files = append(files, file)
+	}
+	err = orderInputFiles(files, parameters.OtherString(Expand_Order), int64(parameters.OtherInt(Expand_ShuffleSeed)))
+	return
+}
+
+type InputImageReader_Expand_CustomParameters struct {
+	recursive   bool
+	extensions  string
+	minSize     string
+	include     string
+	exclude     string
+	order       string
+	shuffleSeed int
+}
+
+func InputImageReader_Expand_Init(inner InputImageReader) InputImageReader_Expand {
+	return InputImageReader_Expand{new(InputImageReader_Expand_CustomParameters), inner}
+}
+
+// An InputImageReader that expands directories, glob patterns, and '@'-prefixed list files among
+// the input files into a filtered and ordered list of image files, then passes that list to
+// another InputImageReader.
+type InputImageReader_Expand struct {
+	p     *InputImageReader_Expand_CustomParameters
+	inner InputImageReader
+}
+
+func (iie InputImageReader_Expand) RegisterCustomParameters(parameters *Parameters) bool {
+	flag.BoolVar(&(iie.p.recursive), "recursive", false, "Include images in subdirectories of input directories")
+	flag.StringVar(&(iie.p.extensions), "ext", expand_DefaultExtensions, "Comma-separated extensions of the files to take from input directories and patterns")
+	flag.StringVar(&(iie.p.minSize), "min-size", "", "Skip input images smaller than these dimensions")
+	flag.StringVar(&(iie.p.include), "include", "", "Comma-separated patterns; use only input files whose names match one")
+	flag.StringVar(&(iie.p.exclude), "exclude", "", "Comma-separated patterns; skip input files whose names match one")
+	flag.StringVar(&(iie.p.order), "order", "", "Order input files by 'name', 'mtime', 'exif-date', 'size', or 'shuffle' (default: as given)")
+	flag.IntVar(&(iie.p.shuffleSeed), "shuffle-seed", 0, "Seed for '-order shuffle'")
+	return iie.inner.RegisterCustomParameters(parameters)
+}
+
+func (iie InputImageReader_Expand) ParseCustomParameters(parameters *Parameters) bool {
+	parameters.SetOther(Expand_Recursive, iie.p.recursive)
+	parameters.SetOther(Expand_Extensions, iie.p.extensions)
+	if iie.p.minSize == "" {
+		parameters.SetOther(Expand_MinSize, NewDims(0, 0))
+	} else {
+		minSize, err := ParseDims(iie.p.minSize)
+		if err != nil {
+			parameters.ProgressMonitor().ReportMessage(err.Error())
+			return false
+		}
+		parameters.SetOther(Expand_MinSize, minSize)
+	}
+	parameters.SetOther(Expand_Include, iie.p.include)
+	parameters.SetOther(Expand_Exclude, iie.p.exclude)
+	switch strings.ToLower(iie.p.order) {
+	case "", "name", "mtime", "exif-date", "size", "shuffle":
+		parameters.SetOther(Expand_Order, strings.ToLower(iie.p.order))
+	default:
+		parameters.ProgressMonitor().ReportMessage("-order value must be 'name', 'mtime', 'exif-date', 'size', or 'shuffle'")
+		return false
+	}
+	if parameters.OtherString(Expand_Order) == "shuffle" {
+		parameters.ProgressMonitor().ReportMessage(fmt.Sprintf("Seed for shuffling input files: %d", iie.p.shuffleSeed))
+	}
+	parameters.SetOther(Expand_ShuffleSeed, iie.p.shuffleSeed)
+	return iie.inner.ParseCustomParameters(parameters)
+}
+
+func (iie InputImageReader_Expand) ReadInputImages(parameters *Parameters) (il ImageLayout, err error) {
+	start := time.Now()
+	files, err := ExpandInputFiles(parameters)
+	if err != nil {
+		il = CreateNilImageLayout()
+		return
+	}
+	if len(files) == 0 {
+		il, err = CreateNilImageLayout(), errors.New("no input images")
+		return
+	}
+	parameters.ProgressMonitor().ReportMessage(fmt.Sprintf("Expanded input to %d images in %s", len(files), time.Since(start).Round(time.Millisecond)))
+	parameters.SetInFiles(files)
+	return iie.inner.ReadInputImages(parameters)
+}
@@ -0,0 +1,55 @@
+package CollageCreator
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// Creates an InputImageReader_Expand around a raster reader, with its parameters parsed
+// as if given on the command line as 'minSize' and 'order' and otherwise left as their
+// defaults.
+func newExpandTestReader(t *testing.T, parameters *Parameters, minSize, order string, skip bool) InputImageReader_Expand {
+	iie := InputImageReader_Expand_Init(InputImageReader_Raster_Init())
+	iie.p.extensions, iie.p.minSize, iie.p.order, iie.p.shuffleSeed = expand_DefaultExtensions, minSize, order, 42
+	raster := iie.inner.(InputImageReader_Raster)
+	raster.p.workers, raster.p.skipUnreadable = 1, skip
+	if !iie.ParseCustomParameters(parameters) {
+		t.Fatal("parsing the parameters failed")
+	}
+	return iie
+}
+
+func TestMinSizeSkipsUnreadableImages(t *testing.T) {
+	good, truncated, broken := writeUnreadableTestFiles(t)
+	var messages []string
+	parameters := newRecordingTestParameters(&messages)
+	parameters.SetInFiles([]string{good, broken, truncated})
+	iie := newExpandTestReader(t, parameters, "16x16", "", true)
+	imageLayout, err := iie.ReadInputImages(parameters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(imageLayout.Images(false)); n != 2 {
+		t.Errorf("read %d images, want the file that is not an image left out", n)
+	}
+	if failures := readFailureLogOf(parameters).Failures(); len(failures) != 1 || failures[0].FileName != broken {
+		t.Errorf("recorded failures %v, want one for %s", failures, broken)
+	}
+
+	parameters = newRecordingTestParameters(&messages)
+	parameters.SetInFiles([]string{good, broken})
+	newExpandTestReader(t, parameters, "16x16", "", false)
+	if _, err := ExpandInputFiles(parameters); err == nil {
+		t.Error("an unreadable file did not fail -min-size without -skip-unreadable")
+	}
+}
+
+func TestShuffleOrderReportsSeed(t *testing.T) {
+	var messages []string
+	parameters := newRecordingTestParameters(&messages)
+	newExpandTestReader(t, parameters, "", "Shuffle", false)
+	if want := fmt.Sprintf("Seed for shuffling input files: %d", 42); !reflect.DeepEqual(messages, []string{want}) {
+		t.Errorf("reported %q, want %q", messages, want)
+	}
+}
@@ -0,0 +1,318 @@
+package CollageCreator
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	Manifest_Entries string = "Manifest_Entries"
+)
+
+// The attributes that a manifest may give an input image beyond its pathname.
+type ImageAnnotations struct {
+	// A caption for the image, or "" if none.
+	Caption string
+	// The name of a group to which the image belongs, or "" if none.
+	Group string
+	// The importance weight of the image.
+	Weight float64
+	// Whether 'Weight' was given, rather than left at its default of 1.
+	WeightGiven bool
+	// Whether the image is pinned at 'Position' on the canvas.
+	Pinned bool
+	// The position of the image's upper left corner on the canvas, if 'Pinned' is set.
+	Position Dims
+	// How the image is to be cropped, or an empty geometry if it is not.
+	Cropping Geometry
+	// How the image is to be scaled, or an empty geometry if it is not.
+	Scaling Geometry
+}
+
+// Implemented by an 'ImageInfo' that carries the attributes given its image by a manifest.
+type AnnotatedImageInfo interface {
+	ImageInfo
+	// Gets the attributes given this image by the manifest.
+	Annotations() ImageAnnotations
+}
+
+// Gets the attributes given an image by a manifest, returning false if it has none. Any
+// 'ImageInfo' wrapping the annotated one is looked through.
+func AnnotationsOf(info ImageInfo) (annotations ImageAnnotations, valid bool) {
+	for {
+		if annotated, ok := info.(AnnotatedImageInfo); ok {
+			return annotated.Annotations(), true
+		}
+		wrapped, ok := info.(WrappedImageInfo)
+		if !ok {
+			return ImageAnnotations{Weight: 1, Cropping: EmptyGeometry(), Scaling: EmptyGeometry()}, false
+		}
+		info = wrapped.Unwrap()
+	}
+}
+
+// Implemented by an 'ImageInfo' that wraps another, adding to it.
+type WrappedImageInfo interface {
+	ImageInfo
+	// Gets the 'ImageInfo' that this one wraps.
+	Unwrap() ImageInfo
+}
+
+// Gets the innermost 'ImageInfo' wrapped by the given one, or the given one if it wraps none.
+// The optional interfaces that an 'ImageInfo' may implement are to be checked on the result.
+func UnwrapImageInfo(info ImageInfo) ImageInfo {
+	for {
+		wrapped, ok := info.(WrappedImageInfo)
+		if !ok {
+			return info
+		}
+		info = wrapped.Unwrap()
+	}
+}
+
+// An ImageInfo implementation that adds the attributes given by a manifest to another 'ImageInfo'.
+type ImageInfo_annotated struct {
+	ImageInfo
+	annotations ImageAnnotations
+}
+
+func (iia ImageInfo_annotated) Annotations() ImageAnnotations {
+	return iia.annotations
+}
+
+func (iia ImageInfo_annotated) Unwrap() ImageInfo {
+	return iia.ImageInfo
+}
+
+func (iia ImageInfo_annotated) ImageDataAtSize(size Dims) interface{} {
+	return ImageDataAtSize(iia.ImageInfo, size)
+}
+
+func (iia ImageInfo_annotated) Orientation() int {
+	return OrientationOf(iia.ImageInfo)
+}
+
+func (iia ImageInfo_annotated) BitDepth() int {
+	return BitDepthOf(iia.ImageInfo)
+}
+
+// One row of an input manifest.
+type ManifestEntry struct {
+	Path string
+	ImageAnnotations
+}
+
+// The form of a row of a JSON input manifest.
+type manifestEntry_json struct {
+	Path     string   `json:"path"`
+	Caption  string   `json:"caption"`
+	Group    string   `json:"group"`
+	Weight   *float64 `json:"weight"`
+	Position *struct {
+		X float64 `json:"x"`
+		Y float64 `json:"y"`
+	} `json:"position"`
+	Crop  string `json:"crop"`
+	Scale string `json:"scale"`
+}
+
+// Parses the geometries of a manifest entry, leaving empty geometries for those not given.
+func parseManifestGeometries(entry *ManifestEntry, crop, scale string) (err error) {
+	if entry.Cropping, err = parseGeometryOrEmpty(crop); err != nil {
+		return
+	}
+	if entry.Cropping.HasSize() && !entry.Cropping.HasOffset() {
+		return errors.New("crop geometry must include an offset: '" + crop + "'")
+	}
+	entry.Scaling, err = parseGeometryOrEmpty(scale)
+	return
+}
+
+func readManifest_json(contents []byte) (entries []ManifestEntry, err error) {
+	raw := []manifestEntry_json{}
+	if err = json.Unmarshal(contents, &raw); err != nil {
+		return
+	}
+	for i, row := range raw {
+		entry := ManifestEntry{Path: row.Path, ImageAnnotations: ImageAnnotations{Caption: row.Caption, Group: row.Group, Weight: 1}}
+		if row.Weight != nil {
+			entry.Weight, entry.WeightGiven = *row.Weight, true
+		}
+		if row.Position != nil {
+			entry.Pinned, entry.Position = true, NewDims(row.Position.X, row.Position.Y)
+		}
+		if err = parseManifestGeometries(&entry, row.Crop, row.Scale); err != nil {
+			err = fmt.Errorf("entry %d: %w", i+1, err)
+			return
+		}
+		entries = append(entries, entry)
+	}
+	return
+}
+
+// Reads a CSV manifest, whose first row names its columns: 'path' and, optionally, 'caption',
+// 'group', 'weight', 'x' and 'y' (together giving a pinned position), 'crop', and 'scale'.
+func readManifest_csv(contents []byte) (entries []ManifestEntry, err error) {
+	reader := csv.NewReader(strings.NewReader(string(contents)))
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil || len(records) == 0 {
+		return
+	}
+	columns := map[string]int{}
+	for i, name := range records[0] {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, in := columns["path"]; !in {
+		err = errors.New("no 'path' column")
+		return
+	}
+	for i, record := range records[1:] {
+		field := func(name string) string {
+			if col, in := columns[name]; in && col < len(record) {
+				return strings.TrimSpace(record[col])
+			}
+			return ""
+		}
+		line := i + 2
+		entry := ManifestEntry{Path: field("path"), ImageAnnotations: ImageAnnotations{Caption: field("caption"), Group: field("group"), Weight: 1}}
+		if entry.Path == "" {
+			continue
+		}
+		if weight := field("weight"); weight != "" {
+			if entry.Weight, err = strconv.ParseFloat(weight, 64); err != nil {
+				err = fmt.Errorf("line %d: %w", line, err)
+				return
+			}
+			entry.WeightGiven = true
+		}
+		if x, y := field("x"), field("y"); x != "" || y != "" {
+			var px, py float64
+			if px, err = strconv.ParseFloat(x, 64); err == nil {
+				py, err = strconv.ParseFloat(y, 64)
+			}
+			if err != nil {
+				err = fmt.Errorf("line %d: pinned position needs both 'x' and 'y': %w", line, err)
+				return
+			}
+			entry.Pinned, entry.Position = true, NewDims(px, py)
+		}
+		if err = parseManifestGeometries(&entry, field("crop"), field("scale")); err != nil {
+			err = fmt.Errorf("line %d: %w", line, err)
+			return
+		}
+		entries = append(entries, entry)
+	}
+	return
+}
+
+// Reads an input manifest: either a CSV file with a header row or a JSON file holding an array
+// of objects, each giving the pathname of an image and, optionally, its caption, group, weight,
+// pinned position, and crop and scale geometries. Relative pathnames are resolved against the
+// directory holding the manifest.
+func ReadInputManifest(fileName string) (entries []ManifestEntry, err error) {
+	contents, err := os.ReadFile(fileName)
+	if err != nil {
+		return
+	}
+	if strings.ToLower(filepath.Ext(fileName)) == ".json" {
+		entries, err = readManifest_json(contents)
+	} else {
+		entries, err = readManifest_csv(contents)
+	}
+	if err != nil {
+		err = fmt.Errorf("%s: %w", fileName, err)
+		return
+	}
+	for i := range entries {
+		if entries[i].Weight <= 0 {
+			err = fmt.Errorf("%s: weight must be positive: %s", fileName, entries[i].Path)
+			return
+		}
+		if !filepath.IsAbs(entries[i].Path) {
+			entries[i].Path = filepath.Join(filepath.Dir(fileName), entries[i].Path)
+		}
+	}
+	return
+}
+
+type InputImageReader_Manifest_CustomParameters struct {
+	manifest string
+}
+
+func InputImageReader_Manifest_Init(inner InputImageReader) InputImageReader_Manifest {
+	return InputImageReader_Manifest{new(InputImageReader_Manifest_CustomParameters), inner}
+}
+
+// An InputImageReader that takes its input files from a CSV or JSON manifest, reads them with
+// another InputImageReader, and attaches the attributes the manifest gives each image to its
+// 'ImageInfo' (see 'AnnotationsOf'). The weight, cropping, and scaling given in the manifest
+// are also set on the layout, where 'DimensionInitializer_Original' leaves them in place.
+type InputImageReader_Manifest struct {
+	p     *InputImageReader_Manifest_CustomParameters
+	inner InputImageReader
+}
+
+func (iim InputImageReader_Manifest) RegisterCustomParameters(parameters *Parameters) bool {
+	flag.StringVar(&(iim.p.manifest), "manifest", "", "CSV or JSON manifest listing the input images and their attributes")
+	return iim.inner.RegisterCustomParameters(parameters)
+}
+
+func (iim InputImageReader_Manifest) ParseCustomParameters(parameters *Parameters) bool {
+	if iim.p.manifest != "" {
+		entries, err := ReadInputManifest(iim.p.manifest)
+		if err != nil {
+			parameters.ProgressMonitor().ReportMessage(err.Error())
+			return false
+		}
+		parameters.SetOther(Manifest_Entries, entries)
+	}
+	return iim.inner.ParseCustomParameters(parameters)
+}
+
+func (iim InputImageReader_Manifest) ReadInputImages(parameters *Parameters) (il ImageLayout, err error) {
+	entriesI, valid := parameters.Other(Manifest_Entries)
+	if !valid {
+		return iim.inner.ReadInputImages(parameters)
+	}
+	entries, valid := entriesI.([]ManifestEntry)
+	if !valid {
+		il, err = CreateNilImageLayout(), errors.New("mistyped input manifest")
+		return
+	}
+	if len(parameters.InFiles()) > 0 {
+		il, err = CreateNilImageLayout(), errors.New("input files may not be given alongside a manifest")
+		return
+	}
+	files := make([]string, len(entries))
+	byFile := map[string][]ManifestEntry{}
+	for i, entry := range entries {
+		files[i] = entry.Path
+		byFile[entry.Path] = append(byFile[entry.Path], entry)
+	}
+	parameters.SetInFiles(files)
+	if il, err = iim.inner.ReadInputImages(parameters); err != nil {
+		return
+	}
+	for _, img := range il.Images(false) {
+		info := il.ImageInfoOf(img)
+		queue := byFile[info.FileName()]
+		if len(queue) == 0 {
+			continue
+		}
+		entry := queue[0]
+		byFile[info.FileName()] = queue[1:]
+		il = setImageInfo(il, img, ImageInfo_annotated{info, entry.ImageAnnotations})
+		il = il.SetWeight(img, entry.Weight)
+		il.SetCropping(img, entry.Cropping)
+		il.SetScaling(img, entry.Scaling)
+	}
+	return
+}
@@ -0,0 +1,290 @@
+package CollageCreator
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+)
+
+// An input image held in memory as the bytes of an encoded image file, under a name
+// by which it is reported.
+type NamedImageData struct {
+	Name string
+	Data []byte
+}
+
+// Numbers in-memory images, so that each is cached under a distinct key.
+var memoryImageCounter int64
+
+// The MIME types of the image formats named by 'detectImageFormat'.
+var imageFormatMimeTypes = map[string]string{
+	"JPEG": "image/jpeg",
+	"PNG":  "image/png",
+	"GIF":  "image/gif",
+	"BMP":  "image/bmp",
+	"TIFF": "image/tiff",
+	"WebP": "image/webp",
+}
+
+// Implemented by an 'ImageInfo' whose image is held in memory rather than in a file, so that
+// renderers that would otherwise refer to the file can embed the image or write it out.
+type EncodedImageInfo interface {
+	ImageInfo
+	// Gets the image encoded as a file, along with its MIME type and usual file extension.
+	EncodedImage() (data []byte, mimeType string, extension string, err error)
+}
+
+// An ImageInfo implementation for an image held in memory, either decoded or as the
+// bytes of an encoded image file.
+type ImageInfo_memory struct {
+	id          ImageIdentifier
+	name        string
+	cacheKey    string
+	img         image.Image
+	data        []byte
+	dims        Dims
+	orientation int
+	color       colorManagement
+	depth       int
+	// Where a failure to decode the image is recorded, if unreadable images are being skipped.
+	failures *ReadFailureLog
+	// Where the decoded image is kept until it is needed again, once it is part of a layout.
+	cache *ImageCache
+}
+
+func (iim ImageInfo_memory) ImageId() ImageIdentifier {
+	return iim.id
+}
+
+func (iim ImageInfo_memory) FileName() string {
+	return iim.name
+}
+
+func (iim ImageInfo_memory) DimensionsOf() Dims {
+	return iim.dims
+}
+
+func (iim ImageInfo_memory) decode() image.Image {
+	rv, err := iim.tryDecode()
+	if err != nil {
+		if iim.failures == nil {
+			log.Fatal(err)
+		}
+		iim.failures.Record(iim.name, err)
+		return unreadableImageTile(iim.dims)
+	}
+	return rv
+}
+
+// As 'decode', but returns an error on failure.
+func (iim ImageInfo_memory) tryDecode() (image.Image, error) {
+	rv, err := decodeImage(bytes.NewReader(iim.data), iim.color)
+	if err != nil {
+		return nil, imageDecodeError(iim.name, bytes.NewReader(iim.data), err)
+	}
+	return ApplyExifOrientation(rv, iim.orientation), nil
+}
+
+func (iim ImageInfo_memory) ImageData() interface{} {
+	if iim.img != nil {
+		return iim.img
+	}
+	return iim.cache.fetch(iim.cacheKey, 1, iim.dims, iim.decode)
+}
+
+func (iim ImageInfo_memory) ImageDataAtSize(size Dims) interface{} {
+	if iim.img != nil {
+		return iim.img
+	}
+	return iim.cache.fetch(iim.cacheKey, reductionFactor(iim.dims, size), iim.dims, iim.decode)
+}
+
+func (iim ImageInfo_memory) Orientation() int {
+	return iim.orientation
+}
+
+func (iim ImageInfo_memory) BitDepth() int {
+	return iim.depth
+}
+
+func (iim ImageInfo_memory) EncodedImage() (data []byte, mimeType string, extension string, err error) {
+	if iim.data != nil {
+		if mimeType, known := imageFormatMimeTypes[detectImageFormat(iim.data)]; known {
+			return iim.data, mimeType, "." + mimeType[len("image/"):], nil
+		}
+	}
+	img, isImage := iim.ImageData().(image.Image)
+	if !isImage {
+		err = fmt.Errorf("image data cannot be encoded: %s", iim.name)
+		return
+	}
+	var buffer bytes.Buffer
+	if err = png.Encode(&buffer, img); err != nil {
+		return
+	}
+	return buffer.Bytes(), "image/png", ".png", nil
+}
+
+// Creates an 'ImageInfo' for an image already decoded.
+func NewImageInfoFromImage(id ImageIdentifier, name string, img image.Image) ImageInfo {
+	bounds := img.Bounds()
+	return ImageInfo_memory{id: id, name: name, img: img, dims: NewDims(float64(bounds.Dx()), float64(bounds.Dy())), orientation: 1, depth: colorModelBitDepth(img.ColorModel())}
+}
+
+// Creates an 'ImageInfo' for an image held as the bytes of an encoded image file. If 'preload'
+// is set, the image is decoded at once; if not, only its header is read, and it is decoded
+// as necessary. In either case, the image is presented in the orientation given by its EXIF
+// metadata, and with its colours normalized to sRGB where its ICC profile allows.
+func NewImageInfoFromBytes(id ImageIdentifier, name string, data []byte, preload bool) (ImageInfo, error) {
+	reader := bytes.NewReader(data)
+	rv := ImageInfo_memory{id: id, name: name, data: data, orientation: ReadExifOrientation(reader)}
+	rv.color, _ = inspectImageColor(reader)
+	rv.cacheKey = fmt.Sprintf("memory:%d:%s", atomic.AddInt64(&memoryImageCounter, 1), name)
+	config, _, err := image.DecodeConfig(reader)
+	if err != nil {
+		return nil, imageDecodeError(name, reader, err)
+	}
+	rv.depth = colorModelBitDepth(config.ColorModel)
+	rv.dims = OrientedDims(NewDims(float64(config.Width), float64(config.Height)), rv.orientation)
+	if preload {
+		// The decoded image is already in its proper orientation, and replaces the bytes.
+		if rv.img, err = rv.tryDecode(); err != nil {
+			return nil, err
+		}
+		rv.data = nil
+		rv.orientation = 1
+	}
+	return rv, nil
+}
+
+// Creates an image layout holding the given images, identified in order from 0.
+func createImageLayout(parameters *Parameters, infos []ImageInfo) ImageLayout {
+	rv := ImageLayout_impl{data: new(imageLayout_data)}
+	rv.data.size = NewDims(0, 0)
+	rv.data.parameters = parameters
+	rv.data.images = make([]ImageIdentifier, len(infos))
+	rv.data.imageInfo = make(map[ImageIdentifier]ImageInfo)
+	rv.data.dimensions = make(map[ImageIdentifier]Dims)
+	rv.data.cropping = make(map[ImageIdentifier]Geometry)
+	rv.data.scaling = make(map[ImageIdentifier]Geometry)
+	rv.data.positions = make(map[ImageIdentifier]Dims)
+	rv.data.weights = make(map[ImageIdentifier]float64)
+	for i, info := range infos {
+		rv.data.images[i] = info.ImageId()
+		rv.data.imageInfo[info.ImageId()] = info
+		rv.data.dimensions[info.ImageId()] = info.DimensionsOf()
+	}
+	return rv
+}
+
+// Creates an image layout from images already decoded, named 'image 1', 'image 2', etc.
+func CreateImageLayoutFromImages(parameters *Parameters, images []image.Image) ImageLayout {
+	infos := make([]ImageInfo, len(images))
+	for i, img := range images {
+		infos[i] = NewImageInfoFromImage(ImageIdentifier(i), fmt.Sprintf("image %d", i+1), img)
+	}
+	return createImageLayout(parameters, infos)
+}
+
+// Creates an image layout from images held as the bytes of encoded image files. Whether they
+// are decoded at once is governed by the 'Raster_PreloadImages' parameter, if set.
+func CreateImageLayoutFromBytes(parameters *Parameters, inputs []NamedImageData) (il ImageLayout, err error) {
+	preload := false
+	if preloadI, valid := parameters.Other(Raster_PreloadImages); valid {
+		preload, _ = preloadI.(bool)
+	}
+	infos := make([]ImageInfo, len(inputs))
+	for i, input := range inputs {
+		if infos[i], err = NewImageInfoFromBytes(ImageIdentifier(i), input.Name, input.Data, preload); err != nil {
+			il = CreateNilImageLayout()
+			return
+		}
+	}
+	il = createImageLayout(parameters, withParameters(infos, parameters))
+	return
+}
+
+// Creates an image layout from images read from the given readers, named 'input 1', 'input 2',
+// etc. Each reader is read to its end.
+func CreateImageLayoutFromReaders(parameters *Parameters, readers []io.Reader) (il ImageLayout, err error) {
+	inputs := make([]NamedImageData, len(readers))
+	for i, reader := range readers {
+		inputs[i].Name = fmt.Sprintf("input %d", i+1)
+		if inputs[i].Data, err = io.ReadAll(reader); err != nil {
+			il = CreateNilImageLayout()
+			return
+		}
+	}
+	return CreateImageLayoutFromBytes(parameters, inputs)
+}
+
+// Has the in-memory images among 'infos' keep their decoded data in the image cache of
+// 'parameters' and, if unreadable images are being skipped, record their decoding failures
+// rather than ending the run.
+func withParameters(infos []ImageInfo, parameters *Parameters) []ImageInfo {
+	failures := startReadFailureLog(parameters)
+	rv := make([]ImageInfo, len(infos))
+	for i, info := range infos {
+		if memory, ok := info.(ImageInfo_memory); ok {
+			memory.failures, memory.cache = failures, parameters.ImageCache()
+			info = memory
+		}
+		rv[i] = info
+	}
+	return rv
+}
+
+// Converts an image held in memory into a 'data:' URI embedding it.
+func imageDataURI(info EncodedImageInfo) (string, error) {
+	data, mimeType, _, err := info.EncodedImage()
+	if err != nil {
+		return "", err
+	}
+	return "data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// Writes an image held in memory into the directory 'dir', naming the file for the image's
+// identifier. Returns the pathname of the file.
+func writeEncodedImage(info EncodedImageInfo, dir string) (written string, err error) {
+	data, _, extension, err := info.EncodedImage()
+	if err != nil {
+		return
+	}
+	if err = os.MkdirAll(dir, 0777); err != nil {
+		return
+	}
+	written = filepath.Join(dir, fmt.Sprintf("memory-%d%s", info.ImageId(), extension))
+	err = os.WriteFile(written, data, 0666)
+	return
+}
+
+func InputImageReader_Memory_Init(infos []ImageInfo) InputImageReader_Memory {
+	return InputImageReader_Memory{infos}
+}
+
+// An InputImageReader that supplies images already held in memory (see 'NewImageInfoFromImage'
+// and 'NewImageInfoFromBytes'), for use when this library is embedded in another program.
+// The input files given in the parameters are ignored.
+type InputImageReader_Memory struct {
+	infos []ImageInfo
+}
+
+func (iim InputImageReader_Memory) RegisterCustomParameters(parameters *Parameters) bool {
+	return true
+}
+
+func (iim InputImageReader_Memory) ParseCustomParameters(parameters *Parameters) bool {
+	return true
+}
+
+func (iim InputImageReader_Memory) ReadInputImages(parameters *Parameters) (il ImageLayout, err error) {
+	il, err = createImageLayout(parameters, withParameters(iim.infos, parameters)), nil
+	return
+}
@@ -0,0 +1,118 @@
+package CollageCreator
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"math/rand"
+	"testing"
+)
+
+// Encodes a JPEG image of the given size carrying the given EXIF orientation.
+func jpegWithOrientation(t *testing.T, width, height int, orientation uint16) []byte {
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, image.NewGray(image.Rect(0, 0, width, height)), nil); err != nil {
+		t.Fatal(err)
+	}
+	var tiff bytes.Buffer
+	tiff.WriteString("II*\x00")
+	for _, field := range []interface{}{uint32(8), uint16(1), uint16(0x0112), uint16(3), uint32(1), orientation, uint16(0), uint32(0)} {
+		binary.Write(&tiff, binary.LittleEndian, field)
+	}
+	app1 := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	segment := append([]byte{0xFF, 0xE1, byte((len(app1) + 2) >> 8), byte(len(app1) + 2)}, app1...)
+	data := encoded.Bytes()
+	return append(append(append([]byte{}, data[:2]...), segment...), data[2:]...)
+}
+
+func TestMemoryImageOrientation(t *testing.T) {
+	data := jpegWithOrientation(t, 40, 20, 6)
+	for _, preload := range []bool{false, true} {
+		info, err := NewImageInfoFromBytes(0, "rotated.jpg", data, preload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.DimensionsOf() != NewDims(20, 40) {
+			t.Errorf("preload %v: dimensions %v, want 20x40", preload, info.DimensionsOf())
+		}
+		encoded, _, _, err := info.(EncodedImageInfo).EncodedImage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		config, _, err := image.DecodeConfig(bytes.NewReader(encoded))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The encoded image, once turned by the orientation it is reported with, must have
+		// the dimensions of the image as presented.
+		shown := OrientedDims(NewDims(float64(config.Width), float64(config.Height)), OrientationOf(info))
+		if shown != info.DimensionsOf() {
+			t.Errorf("preload %v: encoded image shown at %v, want %v", preload, shown, info.DimensionsOf())
+		}
+	}
+}
+
+// Encodes a PNG image of random pixels, and cuts it short after its header.
+func truncatedPNG(t *testing.T) []byte {
+	img := image.NewGray(image.Rect(0, 0, 64, 64))
+	rand.New(rand.NewSource(1)).Read(img.Pix)
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, img); err != nil {
+		t.Fatal(err)
+	}
+	return encoded.Bytes()[:encoded.Len()/2]
+}
+
+func TestMemoryImageTruncated(t *testing.T) {
+	data := truncatedPNG(t)
+	if _, err := NewImageInfoFromBytes(0, "preloaded.png", data, true); err == nil {
+		t.Error("preloading a truncated image did not fail")
+	}
+
+	var messages []string
+	parameters := newRecordingTestParameters(&messages)
+	parameters.SetOther(Raster_SkipUnreadable, true)
+	imageLayout, err := CreateImageLayoutFromBytes(parameters, []NamedImageData{{"lazy.png", data}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := imageLayout.Images(false)[0]
+	decoded, _ := imageLayout.ImageInfoOf(img).ImageData().(image.Image)
+	if decoded == nil || decoded.Bounds() != image.Rect(0, 0, 64, 64) {
+		t.Fatalf("decoded %v, want a placeholder tile of 64x64", decoded)
+	}
+	failures := readFailureLogOf(parameters).Failures()
+	if len(failures) != 1 || failures[0].FileName != "lazy.png" {
+		t.Errorf("recorded failures %v, want one for lazy.png", failures)
+	}
+}
+
+func TestRenderSubImage(t *testing.T) {
+	red, blue := color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 255, 255}
+	full := image.NewRGBA(image.Rect(0, 0, 20, 20))
+	draw.Draw(full, image.Rect(0, 0, 10, 20), image.NewUniform(red), image.Point{}, draw.Src)
+	draw.Draw(full, image.Rect(10, 0, 20, 20), image.NewUniform(blue), image.Point{}, draw.Src)
+	sub := full.SubImage(image.Rect(10, 5, 20, 15))
+	for _, scaling := range []Geometry{EmptyGeometry(), NewScalingGeometry(NewDims(10, 10))} {
+		parameters := newTestParameters()
+		imageLayout := CreateImageLayoutFromImages(parameters, []image.Image{sub})
+		img := imageLayout.Images(false)[0]
+		imageLayout.SetCropping(img, EmptyGeometry())
+		imageLayout.SetScaling(img, scaling)
+		imageLayout.SetPosition(img, NewDims(0, 0))
+		imageLayout.SetCanvasSize(NewDims(10, 10))
+		collage, err := createCollageImage(imageLayout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, at := range []image.Point{{0, 0}, {5, 5}, {9, 9}} {
+			if r, _, b, _ := collage.At(at.X, at.Y).RGBA(); r != 0 || b != 0xffff {
+				t.Errorf("scaling %v: pixel %v is not blue", scaling, at)
+			}
+		}
+	}
+}
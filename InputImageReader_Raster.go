@@ -1,22 +1,70 @@
 package CollageCreator
 
 import (
+	"bytes"
 	"flag"
+	"fmt"
 	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
 	"log"
-	"os"
+	"runtime"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
 )
 
 const (
 	Raster_PreloadImages string = "Raster_PreloadImages"
+	Raster_Workers       string = "Raster_Workers"
+	Raster_CacheSize     string = "Raster_CacheSize"
+	Raster_GifFrameStep  string = "Raster_GifFrameStep"
 )
 
+// Implemented by an 'ImageInfo' that knows the number of bits per channel
+// with which its image is stored.
+type BitDepthImageInfo interface {
+	ImageInfo
+	// Gets the number of bits per colour channel (8 or 16).
+	BitDepth() int
+}
+
+// Gets the number of bits per colour channel of the given image, or 8 if it
+// is not known.
+func BitDepthOf(info ImageInfo) int {
+	if deep, ok := info.(BitDepthImageInfo); ok {
+		return deep.BitDepth()
+	}
+	return 8
+}
+
+// Gets the number of bits per colour channel of images in the given colour model.
+func colorModelBitDepth(model color.Model) int {
+	switch model {
+	case color.RGBA64Model, color.NRGBA64Model, color.Gray16Model, color.Alpha16Model:
+		return 16
+	}
+	return 8
+}
+
 // An ImageInfo implementation that stores only the filename and dimension
 // of an image and not all the pixel data.
 type ImageInfo_placeholder struct {
-	id       ImageIdentifier
-	fileName string
-	dims     Dims
+	id          ImageIdentifier
+	fileName    string
+	dims        Dims
+	orientation int
+	color       colorManagement
+	depth       int
+	// Where to record a failure to decode the image, if it is to be drawn as a placeholder tile
+	// rather than ending the run.
+	failures *ReadFailureLog
+	// Where the decoded image is kept until it is needed again.
+	cache *ImageCache
 }
 
 func (iip ImageInfo_placeholder) ImageId() ImageIdentifier {
@@ -31,24 +79,59 @@ func (iip ImageInfo_placeholder) DimensionsOf() Dims {
 	return iip.dims
 }
 
-func (iip ImageInfo_placeholder) ImageData() interface{} {
-	reader, err := os.Open(string(iip.fileName))
+// Decodes the image in full. On failure, logs a fatal error, or, if unreadable images are
+// being skipped, records the failure and returns a placeholder tile.
+func (iip ImageInfo_placeholder) decode() image.Image {
+	rv, err := iip.tryDecode()
 	if err != nil {
-		log.Fatal(err)
+		if iip.failures == nil {
+			log.Fatal(err)
+		}
+		iip.failures.Record(iip.fileName, err)
+		return unreadableImageTile(iip.dims)
+	}
+	return rv
+}
+
+// As 'decode', but returns an error on failure.
+func (iip ImageInfo_placeholder) tryDecode() (image.Image, error) {
+	if _, _, isFrame := SplitFrameName(iip.fileName); isFrame {
+		return gifFrameImage(iip.fileName)
 	}
-	defer reader.Close()
-	rv, _, err := image.Decode(reader)
+	reader, closer, err := openImageSource(iip.fileName)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	return rv
+	defer closer()
+	rv, err := decodeImage(reader, iip.color)
+	if err != nil {
+		return nil, imageDecodeError(iip.fileName, reader, err)
+	}
+	return ApplyExifOrientation(rv, iip.orientation), nil
+}
+
+func (iip ImageInfo_placeholder) ImageData() interface{} {
+	return iip.cache.fetch(iip.fileName, 1, iip.dims, iip.decode)
+}
+
+func (iip ImageInfo_placeholder) ImageDataAtSize(size Dims) interface{} {
+	return iip.cache.fetch(iip.fileName, reductionFactor(iip.dims, size), iip.dims, iip.decode)
+}
+
+func (iip ImageInfo_placeholder) Orientation() int {
+	return iip.orientation
+}
+
+func (iip ImageInfo_placeholder) BitDepth() int {
+	return iip.depth
 }
 
 // An ImageInfo implementation that stores all of an image's pixel data.
 type ImageInfo_impl struct {
-	id       ImageIdentifier
-	fileName string
-	img      image.Image
+	id          ImageIdentifier
+	fileName    string
+	img         image.Image
+	orientation int
 }
 
 func (iii ImageInfo_impl) ImageId() ImageIdentifier {
@@ -67,51 +150,161 @@ func (iii ImageInfo_impl) ImageData() interface{} {
 	return iii.img
 }
 
+func (iii ImageInfo_impl) Orientation() int {
+	return iii.orientation
+}
+
+func (iii ImageInfo_impl) BitDepth() int {
+	return colorModelBitDepth(iii.img.ColorModel())
+}
+
+// Known image file signatures, used to name the format of a file that cannot be decoded.
+var imageFormatSignatures = []struct {
+	offset    int
+	signature string
+	name      string
+}{
+	{0, "\xff\xd8\xff", "JPEG"},
+	{0, "\x89PNG\r\n\x1a\n", "PNG"},
+	{0, "GIF8", "GIF"},
+	{0, "BM", "BMP"},
+	{0, "II*\x00", "TIFF"},
+	{0, "MM\x00*", "TIFF"},
+	{8, "WEBP", "WebP"},
+	{4, "ftypheic", "HEIF"},
+	{4, "ftypheix", "HEIF"},
+	{4, "ftypmif1", "HEIF"},
+	{4, "ftypavif", "AVIF"},
+	{0, "\xff\x0a", "JPEG XL"},
+	{0, "\x00\x00\x00\x0cJXL ", "JPEG XL"},
+	{0, "\x00\x00\x01\x00", "ICO"},
+	{0, "8BPS", "Photoshop"},
+	{0, "%PDF", "PDF"},
+	{0, "<svg", "SVG"},
+	{0, "<?xml", "XML"},
+}
+
+// Names the format of an image file from its first bytes, or returns "unrecognized".
+func detectImageFormat(header []byte) string {
+	for _, sig := range imageFormatSignatures {
+		if len(header) >= sig.offset+len(sig.signature) && bytes.Equal(header[sig.offset:sig.offset+len(sig.signature)], []byte(sig.signature)) {
+			return sig.name
+		}
+	}
+	return "unrecognized"
+}
+
+// Wraps an error from decoding the given file, naming the file and, if the
+// error is that the format is unsupported, the format detected.
+func imageDecodeError(fileName string, reader io.ReaderAt, err error) error {
+	if err == image.ErrFormat {
+		header := make([]byte, 16)
+		n, _ := reader.ReadAt(header, 0)
+		return fmt.Errorf("%s: unsupported image format (%s)", fileName, detectImageFormat(header[:n]))
+	}
+	return fmt.Errorf("%s: %w", fileName, err)
+}
+
 // Loads an image for inclusion in an ImageLayout. If 'preload' is set, the
 // entire image is loaded into memory; if not, only the header is read
-// to obtain the dimensions.
+// to obtain the dimensions. In either case, the image is presented in the
+// orientation given by its EXIF metadata, and with its colours normalized to
+// sRGB. Logs a fatal error on failure.
 func LoadImage(id ImageIdentifier, fileName string, preload bool) ImageInfo {
-	reader, err := os.Open(string(fileName))
+	rv, _, err := loadImage(id, fileName, preload)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer reader.Close()
-	if preload {
-		rv, _, err := image.Decode(reader)
-		if err != nil {
-			log.Fatal(err)
+	return rv
+}
+
+// As 'LoadImage', but returns an error on failure, along with a description
+// of the problem if the image's colours cannot be managed.
+func loadImage(id ImageIdentifier, fileName string, preload bool) (info ImageInfo, colorIssue string, err error) {
+	sourceName, _, isFrame := SplitFrameName(fileName)
+	reader, closer, err := openImageSource(sourceName)
+	if err != nil {
+		return
+	}
+	defer closer()
+	header := make([]byte, 16)
+	n, _ := reader.ReadAt(header, 0)
+	if !isFrame && isSVGFile(fileName, header[:n]) {
+		info, err = loadVectorImage(id, fileName, reader)
+		return
+	}
+	orientation := ReadExifOrientation(reader)
+	cm, colorIssue := inspectImageColor(reader)
+	if isFrame && preload {
+		rv, errF := gifFrameImage(fileName)
+		if errF != nil {
+			return nil, colorIssue, errF
+		}
+		info = ImageInfo_impl{id, fileName, rv, 1}
+	} else if preload {
+		rv, errD := decodeImage(reader, cm)
+		if errD != nil {
+			return nil, colorIssue, imageDecodeError(fileName, reader, errD)
 		}
-		return ImageInfo_impl{id, fileName, rv}
+		info = ImageInfo_impl{id, fileName, ApplyExifOrientation(rv, orientation), orientation}
 	} else {
-		rvC, _, err := image.DecodeConfig(reader)
-		if err != nil {
-			log.Fatal(err)
+		rvC, _, errD := image.DecodeConfig(reader)
+		if errD != nil {
+			return nil, colorIssue, imageDecodeError(fileName, reader, errD)
 		}
-		return ImageInfo_placeholder{id, fileName, NewDims(float64(rvC.Width), float64(rvC.Height))}
+		info = ImageInfo_placeholder{id, fileName, OrientedDims(NewDims(float64(rvC.Width), float64(rvC.Height)), orientation), orientation, cm, colorModelBitDepth(rvC.ColorModel), nil, nil}
 	}
+	return
 }
 
 type InputImageReader_Raster_CustomParameters struct {
-	preload bool
+	preload        bool
+	workers        int
+	cacheSize      int
+	gifFrames      int
+	skipUnreadable bool
 }
 
 func InputImageReader_Raster_Init() InputImageReader_Raster {
 	return InputImageReader_Raster{new(InputImageReader_Raster_CustomParameters)}
 }
 
-// An InputImageReader that reads raster images in any format supported by the Go
-// 'image' library.
+// An InputImageReader that reads raster images in JPEG, PNG, GIF, BMP, TIFF, or WebP
+// format, or any other format registered with the Go 'image' library. Input files may
+// also be zip or tar archives, or patterns within them (e.g. 'photos.zip!*.jpg'), and
+// animated GIFs may be expanded into their frames (e.g. 'clip.gif#frame12'). SVG files are
+// read as vector images, whose dimensions only are known (see 'VectorImageInfo').
 type InputImageReader_Raster struct {
 	p *InputImageReader_Raster_CustomParameters
 }
 
 func (iicio InputImageReader_Raster) RegisterCustomParameters(parameters *Parameters) bool {
 	flag.BoolVar(&(iicio.p.preload), "1", false, "Preload all images, rather than loading dimensions at the start and data as necessary")
+	flag.IntVar(&(iicio.p.workers), "workers", runtime.NumCPU(), "Number of input images to read at once")
+	flag.IntVar(&(iicio.p.cacheSize), "cache-mb", int(imageCache_DefaultCapacity>>20), "Megabytes of decoded image data to keep in memory when not preloading")
+	flag.IntVar(&(iicio.p.gifFrames), "gif-frames", 0, "Expand each animated GIF into every Nth of its frames (0: use only the first frame)")
+	flag.BoolVar(&(iicio.p.skipUnreadable), "skip-unreadable", false, "Leave out input images that cannot be read, or draw a placeholder for those that fail only when rendered, rather than stopping")
 	return true
 }
 
 func (iicio InputImageReader_Raster) ParseCustomParameters(parameters *Parameters) bool {
 	parameters.SetOther(Raster_PreloadImages, iicio.p.preload)
+	if iicio.p.workers < 1 {
+		parameters.ProgressMonitor().ReportMessage("-workers value must be at least 1")
+		return false
+	}
+	parameters.SetOther(Raster_Workers, iicio.p.workers)
+	if iicio.p.cacheSize < 0 {
+		parameters.ProgressMonitor().ReportMessage("-cache-mb value must not be negative")
+		return false
+	}
+	parameters.SetOther(Raster_CacheSize, iicio.p.cacheSize)
+	if iicio.p.gifFrames < 0 {
+		parameters.ProgressMonitor().ReportMessage("-gif-frames value must not be negative")
+		return false
+	}
+	parameters.SetOther(Raster_GifFrameStep, iicio.p.gifFrames)
+	parameters.SetOther(Raster_SkipUnreadable, iicio.p.skipUnreadable)
 	return true
 }
 
@@ -119,22 +312,116 @@ func (iicio InputImageReader_Raster) ReadInputImages(parameters *Parameters) (il
 	return readInputImages_Raster(parameters)
 }
 
+// The result of loading one input image.
+type loadImageResult struct {
+	index      int
+	info       ImageInfo
+	colorIssue string
+	err        error
+}
+
+// Loads the given files with 'workers' goroutines at once, reporting each file as it is
+// read. The results are returned in the order of the files, so that the identifier assigned
+// to each image does not depend on the order in which the loading finishes. On failure,
+// returns the error for the earliest file that failed; if 'failures' is given, the failure is
+// instead recorded there and the file's entry in the results left nil.
+func loadImages(parameters *Parameters, files []string, preload bool, workers int, failures *ReadFailureLog) (infos []ImageInfo, err error) {
+	infos = make([]ImageInfo, len(files))
+	if workers > len(files) {
+		workers = len(files)
+	}
+	indices := make(chan int)
+	results := make(chan loadImageResult, workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			for i := range indices {
+				info, colorIssue, err := loadImage(ImageIdentifier(i), files[i], preload)
+				results <- loadImageResult{i, info, colorIssue, err}
+			}
+		}()
+	}
+	go func() {
+		for i := range files {
+			indices <- i
+		}
+		close(indices)
+	}()
+	errIndex := len(files)
+	for done := 1; done <= len(files); done++ {
+		result := <-results
+		if result.err != nil && failures != nil {
+			failures.Record(files[result.index], result.err)
+			parameters.ProgressMonitor().ReportMessage(fmt.Sprintf("Skipping %s (%d/%d): %s", files[result.index], done, len(files), result.err))
+			continue
+		} else if result.err != nil {
+			if result.index < errIndex {
+				errIndex, err = result.index, result.err
+			}
+			continue
+		}
+		if placeholder, ok := result.info.(ImageInfo_placeholder); ok {
+			placeholder.failures = failures
+			placeholder.cache = parameters.ImageCache()
+			result.info = placeholder
+		}
+		infos[result.index] = result.info
+		parameters.ProgressMonitor().ReportMessage(fmt.Sprintf("Read %s (%d/%d)", files[result.index], done, len(files)))
+		if result.colorIssue != "" {
+			parameters.ProgressMonitor().ReportMessage(fmt.Sprintf("Cannot colour-manage %s: %s; its colours are used as they are", files[result.index], result.colorIssue))
+		}
+	}
+	return
+}
+
 func readInputImages_Raster(parameters *Parameters) (il ImageLayout, err error) {
 	files := parameters.InFiles()
 	rv := ImageLayout_impl{data: new(imageLayout_data)}
 	preload := parameters.OtherBool(Raster_PreloadImages)
 	rv.data.size = NewDims(0, 0)
 	rv.data.parameters = parameters
-	rv.data.images = make([]ImageIdentifier, len(files))
 	rv.data.imageInfo = make(map[ImageIdentifier]ImageInfo)
 	rv.data.dimensions = make(map[ImageIdentifier]Dims)
 	rv.data.cropping = make(map[ImageIdentifier]Geometry)
 	rv.data.scaling = make(map[ImageIdentifier]Geometry)
 	rv.data.positions = make(map[ImageIdentifier]Dims)
-	for i, file := range files {
-		rv.data.images[i] = ImageIdentifier(i)
-		rv.data.imageInfo[rv.data.images[i]] = LoadImage(rv.data.images[i], file, preload)
-		rv.data.dimensions[rv.data.images[i]] = rv.data.imageInfo[rv.data.images[i]].DimensionsOf()
+	rv.data.weights = make(map[ImageIdentifier]float64)
+	workers := runtime.NumCPU()
+	if workersI, valid := parameters.Other(Raster_Workers); valid {
+		if workersI, ok := workersI.(int); ok && workersI > 0 {
+			workers = workersI
+		}
+	}
+	if cacheSizeI, valid := parameters.Other(Raster_CacheSize); valid {
+		if cacheSizeI, ok := cacheSizeI.(int); ok {
+			parameters.ImageCache().SetCapacity(int64(cacheSizeI) << 20)
+		}
+	}
+	failures := startReadFailureLog(parameters)
+	if files, err = expandArchives(files); err != nil {
+		il = CreateNilImageLayout()
+		return
+	}
+	if gifFrameStepI, valid := parameters.Other(Raster_GifFrameStep); valid {
+		if gifFrameStep, ok := gifFrameStepI.(int); ok && gifFrameStep > 0 {
+			if files, err = expandGifFrames(files, gifFrameStep, failures); err != nil {
+				il = CreateNilImageLayout()
+				return
+			}
+		}
+	}
+	rv.data.images = make([]ImageIdentifier, 0, len(files))
+	infos, err := loadImages(parameters, files, preload, workers, failures)
+	if err != nil {
+		il = CreateNilImageLayout()
+		return
+	}
+	for _, info := range infos {
+		if info == nil {
+			continue
+		}
+		rv.data.images = append(rv.data.images, info.ImageId())
+		rv.data.imageInfo[info.ImageId()] = info
+		rv.data.dimensions[info.ImageId()] = info.DimensionsOf()
 	}
 	il, err = rv, nil
 	return
@@ -0,0 +1,41 @@
+// This file contains auxiliary methods that convert a positioned image layout,
+// whose positions and dimensions may be fractional, into one in which every
+// image occupies a whole-pixel rectangle, so that all renderers agree and
+// images that share an edge in the layout share it exactly on output.
+package CollageCreator
+
+import "math"
+
+// The resolution to which coordinates are quantized before being rounded,
+// so that two edges differing only by floating-point error snap alike.
+const snapQuantum float64 = 1024
+
+func snapCoordinate(f float64) float64 {
+	return math.Round(math.Round(f*snapQuantum) / snapQuantum)
+}
+
+func snapImage(iLay ImageLayout, img ImageIdentifier) ImageLayout {
+	pos := iLay.PositionOf(img)
+	dims := iLay.DimensionsOf(img)
+	left, top := snapCoordinate(pos.X()), snapCoordinate(pos.Y())
+	right, bottom := snapCoordinate(pos.X()+dims.X()), snapCoordinate(pos.Y()+dims.Y())
+	iLay = resizeImage(iLay, img, NewDims(math.Max(1, right-left), math.Max(1, bottom-top)), math.Round)
+	iLay, _ = iLay.SetPosition(img, NewDims(left, top))
+	return iLay
+}
+
+// Snaps every positioned image in an existing image layout to whole pixels.
+// Each image edge is rounded independently, so two images whose edges coincide
+// before snapping will share them exactly afterwards, with neither a gap nor
+// an overlap between them; scaling and cropping are adjusted to match.
+// 'CreateCollage' calls this between positioning and rendering; any other
+// caller of a 'CollageRenderer' must do the same, as renderers do not snap.
+func SnapToPixels(iLay ImageLayout) ImageLayout {
+	for _, img := range iLay.Images(false) {
+		iLay = snapImage(iLay, img)
+	}
+	if canvasSize := iLay.CanvasSize(); canvasSize != NewDims(0, 0) {
+		iLay.SetCanvasSize(NewDims(snapCoordinate(canvasSize.X()), snapCoordinate(canvasSize.Y())))
+	}
+	return iLay
+}
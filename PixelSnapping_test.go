@@ -0,0 +1,53 @@
+package CollageCreator
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSnapToPixels(t *testing.T) {
+	parameters := newTestParameters()
+	imageLayout := newTestLayout(parameters, NewDims(90, 60), NewDims(60, 60), NewDims(120, 80), NewDims(90, 60), NewDims(60, 60), NewDims(120, 80))
+	images := imageLayout.Images(false)
+	for _, img := range images {
+		imageLayout.SetScaling(img, EmptyGeometry())
+		imageLayout.SetCropping(img, EmptyGeometry())
+	}
+	imageLayout, _ = imageLayout.SetCropping(images[1], MustParseGeometry("40x40+10+5"))
+	// Two rows of three images, each a third of the width and half the height of a canvas
+	// that divides into neither evenly, and so placed at fractional positions.
+	canvas := NewDims(100, 51)
+	size := NewDims(canvas.X()/3, canvas.Y()/2)
+	for i, img := range images {
+		imageLayout = resizeImage(imageLayout, img, size, func(f float64) float64 { return f })
+		imageLayout, _ = imageLayout.SetPosition(img, NewDims(float64(i%3)*size.X(), float64(i/3)*size.Y()))
+	}
+	imageLayout.SetCanvasSize(canvas)
+
+	imageLayout = SnapToPixels(imageLayout)
+	area := 0.0
+	for i, img := range images {
+		pos, dims := imageLayout.PositionOf(img), imageLayout.DimensionsOf(img)
+		for _, f := range []float64{pos.X(), pos.Y(), dims.X(), dims.Y()} {
+			if f != math.Round(f) {
+				t.Errorf("image %d snapped to %v at %v, not whole pixels", i, dims, pos)
+				break
+			}
+		}
+		area += dims.X() * dims.Y()
+		for j, other := range images[:i] {
+			otherPos, otherDims := imageLayout.PositionOf(other), imageLayout.DimensionsOf(other)
+			if pos.X() < otherPos.X()+otherDims.X() && otherPos.X() < pos.X()+dims.X() &&
+				pos.Y() < otherPos.Y()+otherDims.Y() && otherPos.Y() < pos.Y()+dims.Y() {
+				t.Errorf("image %d (%v at %v) overlaps image %d (%v at %v)", i, dims, pos, j, otherDims, otherPos)
+			}
+		}
+	}
+	// Without overlaps, images covering the whole canvas leave no gaps between them.
+	if canvasSize := imageLayout.CanvasSize(); area != canvasSize.X()*canvasSize.Y() {
+		t.Errorf("images cover %v pixels of a %v canvas", area, canvasSize)
+	}
+	if cropped := imageLayout.CroppingOf(images[1]); !cropped.HasOffset() {
+		t.Errorf("snapping lost the cropping of image 1: %s", cropped)
+	}
+}
@@ -0,0 +1,348 @@
+package CollageCreator
+
+import (
+	"errors"
+	"flag"
+	"math"
+)
+
+const (
+	Guillotine_CanvasSize string = "Guillotine_CanvasSize"
+	Guillotine_Gutter     string = "Guillotine_Gutter"
+)
+
+// The approximate number of subtree combinations the slicing-tree search may try. As the
+// number of images grows, fewer subtrees are kept for each run of images, and fewer places
+// to split each run are tried, to stay within it.
+const guillotine_SearchBudget float64 = 5e7
+
+// The width, in natural logarithm of aspect ratio, of the bands within which only one subtree
+// is kept for each run of images.
+const guillotine_AspectBand float64 = 0.02
+
+// How far, in natural logarithm, the aspect ratios of the subtrees kept for runs of more than
+// one image may stray from that of the canvas, so long as any lie within it.
+const guillotine_MaxLogAspect float64 = 2.5
+
+// A node of a slicing tree: either a single image, or two subtrees placed side by side
+// ('horizontal') or one above the other.
+type guillotineNode struct {
+	image      int
+	horizontal bool
+	children   [2]*guillotineNode
+	// At height 'h', with every image shown uncropped, the subtree is 'aspect*h + gutters' wide.
+	aspect  float64
+	gutters float64
+	// The areas of the largest and the smallest image, gutters aside, per unit of the square
+	// of the height of the subtree.
+	largest, smallest float64
+}
+
+// A cell of the canvas, given over to one image.
+type guillotineCell struct {
+	image    int
+	position Dims
+	size     Dims
+}
+
+func combineGuillotineNodes(left, right *guillotineNode, horizontal bool, gutter float64) guillotineNode {
+	rv := guillotineNode{image: -1, horizontal: horizontal, children: [2]*guillotineNode{left, right}}
+	if horizontal {
+		rv.aspect = left.aspect + right.aspect
+		rv.gutters = left.gutters + right.gutters + gutter
+		rv.largest, rv.smallest = math.Max(left.largest, right.largest), math.Min(left.smallest, right.smallest)
+	} else {
+		// The two subtrees have the same width, and their heights and the gutter between them
+		// add up to that of the whole.
+		rv.aspect = 1 / (1/left.aspect + 1/right.aspect)
+		rv.gutters = rv.aspect * (left.gutters/left.aspect + right.gutters/right.aspect - gutter)
+		leftScale, rightScale := rv.aspect/left.aspect, rv.aspect/right.aspect
+		leftScale, rightScale = leftScale*leftScale, rightScale*rightScale
+		rv.largest = math.Max(left.largest*leftScale, right.largest*rightScale)
+		rv.smallest = math.Min(left.smallest*leftScale, right.smallest*rightScale)
+	}
+	return rv
+}
+
+// How many times larger the largest image of a subtree is than the smallest.
+func (gn *guillotineNode) sizeSpread() float64 {
+	return gn.largest / gn.smallest
+}
+
+// The width of a subtree at the given height, with every image shown uncropped.
+func (gn *guillotineNode) widthFor(height float64) float64 {
+	return gn.aspect*height + gn.gutters
+}
+
+// The height of a subtree at the given width, with every image shown uncropped.
+func (gn *guillotineNode) heightFor(width float64) float64 {
+	return (width - gn.gutters) / gn.aspect
+}
+
+// Chooses how many subtrees to keep for each run of images, and how many places to split each
+// run, for a search over 'n' images.
+func guillotineSearchLimits(n int) (keep, splits int) {
+	runs := float64(n) * float64(n) / 2
+	for keep = 64; keep > 4; keep /= 2 {
+		if guillotine_SearchBudget/(runs*float64(keep*keep)*2) >= 8 {
+			break
+		}
+	}
+	splits = int(math.Max(4, guillotine_SearchBudget/(runs*float64(keep*keep)*2)))
+	return
+}
+
+// Finds, for each run of consecutive images, a set of slicing trees covering a range of aspect
+// ratios around that of the canvas. Each tree's aspect ratio is taken at the size a run of its
+// length would have, were the images to share the canvas equally. Of trees with nearly the same
+// aspect ratio, the one whose images are most nearly equal in size is kept, so that none is
+// left too small to see. Returns the trees for the whole run.
+func searchGuillotineTrees(aspects []float64, canvasSize Dims, gutter float64, keep, splits int) []*guillotineNode {
+	n := len(aspects)
+	trees := make([][][]*guillotineNode, n)
+	for i := range trees {
+		trees[i] = make([][]*guillotineNode, n+1)
+		trees[i][i+1] = []*guillotineNode{{image: i, aspect: aspects[i], largest: aspects[i], smallest: aspects[i]}}
+	}
+	lowest := math.Log(canvasSize.X()/canvasSize.Y()) - guillotine_MaxLogAspect
+	bands := make([]guillotineNode, int(2*guillotine_MaxLogAspect/guillotine_AspectBand)+1)
+	for length := 2; length <= n; length++ {
+		area := canvasSize.X() * canvasSize.Y() * float64(length) / float64(n)
+		// Long runs are split only at a few places, spread evenly along them.
+		step := int(math.Max(1, math.Ceil(float64(length-1)/float64(splits))))
+		for i := 0; i+length <= n; i++ {
+			j := i + length
+			for b := range bands {
+				bands[b].aspect = 0
+			}
+			// The tree nearest the range of aspect ratios, should none lie within it.
+			nearest, nearestDistance := guillotineNode{}, math.Inf(1)
+			for k := i + 1 + ((length-1)%step)/2; k < j; k += step {
+				for _, left := range trees[i][k] {
+					for _, right := range trees[k][j] {
+						for _, horizontal := range []bool{true, false} {
+							node := combineGuillotineNodes(left, right, horizontal, gutter)
+							height := math.Sqrt(area / node.aspect)
+							width := node.widthFor(height)
+							if width <= 0 {
+								continue
+							}
+							band := int(math.Floor((math.Log(width/height) - lowest) / guillotine_AspectBand))
+							if band < 0 || band >= len(bands) {
+								distance := math.Min(math.Abs(float64(band)), math.Abs(float64(band-len(bands)+1)))
+								if distance < nearestDistance {
+									nearest, nearestDistance = node, distance
+								}
+							} else if bands[band].aspect == 0 || node.sizeSpread() < bands[band].sizeSpread() {
+								bands[band] = node
+							}
+						}
+					}
+				}
+			}
+			// The fewer trees are kept, the closer to the aspect ratio of the canvas they are kept,
+			// so long as any lie that close.
+			found, outside := []*guillotineNode{}, []*guillotineNode{}
+			margin := int(math.Max(0, float64(len(bands))/2-float64(keep)*0.1/guillotine_AspectBand))
+			for b := range bands {
+				if bands[b].aspect != 0 {
+					node := bands[b]
+					if b >= margin && b < len(bands)-margin {
+						found = append(found, &node)
+					} else {
+						outside = append(outside, &node)
+					}
+				}
+			}
+			if len(found) == 0 {
+				found = outside
+			}
+			if len(found) == 0 && !math.IsInf(nearestDistance, 1) {
+				found = append(found, &nearest)
+			}
+			if len(found) > keep {
+				// Keep trees evenly spread over the range of aspect ratios, including both ends.
+				thinned := make([]*guillotineNode, keep)
+				for t := range thinned {
+					thinned[t] = found[t*(len(found)-1)/(keep-1)]
+				}
+				found = thinned
+			}
+			trees[i][j] = found
+		}
+	}
+	return trees[0][n]
+}
+
+// Divides the rectangle at 'position' of size 'size' among the images of a slicing tree, each
+// split being made in proportion to the sizes the two subtrees would have uncropped. Returns
+// false if the gutters leave no room for some image.
+func placeGuillotineTree(node *guillotineNode, position, size Dims, gutter float64, cells []guillotineCell) bool {
+	if node.image >= 0 {
+		cells[node.image] = guillotineCell{node.image, position, size}
+		return size.X() > 0 && size.Y() > 0
+	}
+	left, right := node.children[0], node.children[1]
+	if node.horizontal {
+		available := size.X() - gutter
+		leftNatural, rightNatural := left.widthFor(size.Y()), right.widthFor(size.Y())
+		if available <= 0 || leftNatural <= 0 || rightNatural <= 0 {
+			return false
+		}
+		leftWidth := available * leftNatural / (leftNatural + rightNatural)
+		return placeGuillotineTree(left, position, NewDims(leftWidth, size.Y()), gutter, cells) &&
+			placeGuillotineTree(right, NewDims(position.X()+leftWidth+gutter, position.Y()), NewDims(available-leftWidth, size.Y()), gutter, cells)
+	}
+	available := size.Y() - gutter
+	topNatural, bottomNatural := left.heightFor(size.X()), right.heightFor(size.X())
+	if available <= 0 || topNatural <= 0 || bottomNatural <= 0 {
+		return false
+	}
+	topHeight := available * topNatural / (topNatural + bottomNatural)
+	return placeGuillotineTree(left, position, NewDims(size.X(), topHeight), gutter, cells) &&
+		placeGuillotineTree(right, NewDims(position.X(), position.Y()+topHeight+gutter), NewDims(size.X(), available-topHeight), gutter, cells)
+}
+
+// Measures how far the cells depart from the native aspect ratios of their images: the sum
+// of the squared logarithms of the ratios between the two.
+func guillotineDistortion(cells []guillotineCell, aspects []float64) float64 {
+	rv := 0.0
+	for _, cell := range cells {
+		skew := math.Log(cell.size.X() / cell.size.Y() / aspects[cell.image])
+		rv += skew * skew
+	}
+	return rv
+}
+
+// Determines the size of the canvas: the requested size, if both dimensions are given;
+// otherwise the missing dimensions follow the aspect ratio of the collage and, if neither
+// is given, the total area of the images.
+func guillotineCanvasSize(imageLayout ImageLayout, requested Dims) Dims {
+	parameters := imageLayout.Parameters()
+	aspect := autoAspectRatio(imageLayout)
+	var rv Dims
+	switch {
+	case requested.X() > 0 && requested.Y() > 0:
+		rv = requested
+	case requested.X() > 0:
+		rv = NewDims(requested.X(), math.Round(requested.X()/aspect))
+	case requested.Y() > 0:
+		rv = NewDims(math.Round(requested.Y()*aspect), requested.Y())
+	default:
+		area := 0.0
+		for _, img := range imageLayout.Images(false) {
+			imgDims := imageLayout.DimensionsOf(img)
+			area += imgDims.X() * imgDims.Y()
+		}
+		width := math.Sqrt(area * aspect)
+		rv = NewDims(math.Round(width), math.Round(width/aspect))
+	}
+	return NewDims(math.Max(rv.X(), parameters.MinCanvasSize().X()), math.Max(rv.Y(), parameters.MinCanvasSize().Y()))
+}
+
+func calculatePositions_Guillotine(imageLayout ImageLayout) (il ImageLayout, err error) {
+	parameters := imageLayout.Parameters()
+	imagesInOrder := imageLayout.Images(true)
+	n := len(imagesInOrder)
+	if n == 0 {
+		err = errors.New("no images to position")
+		return
+	}
+	requested := NewDims(0, 0)
+	if requestedI, valid := parameters.Other(Guillotine_CanvasSize); valid {
+		requested, _ = requestedI.(Dims)
+	}
+	if requested.X() == 0 {
+		requested = NewDims(parameters.MaxCanvasSize().X(), requested.Y())
+	}
+	if requested.Y() == 0 {
+		requested = NewDims(requested.X(), parameters.MaxCanvasSize().Y())
+	}
+	gutter := parameters.OtherFloat(Guillotine_Gutter)
+
+	aspects := make([]float64, n)
+	for i, img := range imagesInOrder {
+		imgDims := imageLayout.DimensionsOf(img)
+		aspects[i] = imgDims.X() / imgDims.Y()
+	}
+	canvasSize := guillotineCanvasSize(imageLayout, requested)
+	if maxDim := parameters.MaxCanvasSize(); (maxDim.X() > 0 && canvasSize.X() > maxDim.X()) || (maxDim.Y() > 0 && canvasSize.Y() > maxDim.Y()) {
+		err = errors.New("guillotine layout is larger than the maximum canvas size")
+		return
+	}
+	keep, splits := guillotineSearchLimits(n)
+	var bestCells []guillotineCell
+	bestDistortion := math.Inf(1)
+	for _, tree := range searchGuillotineTrees(aspects, canvasSize, gutter, keep, splits) {
+		cells := make([]guillotineCell, n)
+		if !placeGuillotineTree(tree, NewDims(0, 0), canvasSize, gutter, cells) {
+			continue
+		}
+		if distortion := guillotineDistortion(cells, aspects); distortion < bestDistortion {
+			bestCells, bestDistortion = cells, distortion
+		}
+	}
+	if bestCells == nil {
+		err = errors.New("gutters leave no room for images within the canvas size")
+		return
+	}
+
+	// Scale each image to cover its cell, then crop away what falls outside it.
+	currentLayout := imageLayout.Duplicate()
+	for _, cell := range bestCells {
+		img := imagesInOrder[cell.image]
+		imgDims := currentLayout.DimensionsOf(img)
+		scale := math.Max(cell.size.X()/imgDims.X(), cell.size.Y()/imgDims.Y())
+		currentLayout = resizeImage(currentLayout, img, NewDims(imgDims.X()*scale, imgDims.Y()*scale), func(f float64) float64 { return f })
+		currentLayout = cropImageCentered(currentLayout, img, cell.size)
+		currentLayout, _ = currentLayout.SetPosition(img, cell.position)
+	}
+	currentLayout.SetCanvasSize(canvasSize)
+	parameters.ProgressMonitor().ReportPositioningSuccess()
+	il = currentLayout
+	return
+}
+
+// A PositionCalculator that fills the canvas exactly, with no space but uniform gutters
+// between images, by cutting it recursively into horizontal and vertical slices. The
+// slices are chosen so that the images need as little cropping as possible to fit them.
+type PositionCalculator_Guillotine struct {
+	p *positionCalculator_Guillotine_Parameters
+}
+type positionCalculator_Guillotine_Parameters struct {
+	canvasSize string
+	gutter     float64
+}
+
+func PositionCalculator_Guillotine_Init() PositionCalculator_Guillotine {
+	return PositionCalculator_Guillotine{new(positionCalculator_Guillotine_Parameters)}
+}
+
+func (pcg PositionCalculator_Guillotine) RegisterCustomParameters(parameters *Parameters) bool {
+	flag.StringVar(&(pcg.p.canvasSize), "canvas-size", "", "(Guillotine placement algorithm) Size of the canvas, as 'WxH'; a missing dimension follows the layout (default: the maximum canvas size)")
+	flag.Float64Var(&(pcg.p.gutter), "gutter", 0, "(Guillotine placement algorithm) Width of the gutters between images")
+	return true
+}
+
+func (pcg PositionCalculator_Guillotine) ParseCustomParameters(parameters *Parameters) bool {
+	canvasSize := NewDims(0, 0)
+	if pcg.p.canvasSize != "" {
+		var err error
+		if canvasSize, err = ParseDims(pcg.p.canvasSize); err != nil {
+			parameters.ProgressMonitor().ReportMessage(err.Error())
+			return false
+		}
+	}
+	if pcg.p.gutter < 0 {
+		parameters.ProgressMonitor().ReportMessage("-gutter value must not be negative")
+		return false
+	}
+	parameters.SetOther(Guillotine_CanvasSize, canvasSize)
+	parameters.SetOther(Guillotine_Gutter, pcg.p.gutter)
+	return true
+}
+
+func (pcg PositionCalculator_Guillotine) CalculatePositions(imageLayout ImageLayout) (il ImageLayout, err error) {
+	il, err = calculatePositions_Guillotine(imageLayout)
+	return
+}
@@ -0,0 +1,73 @@
+package CollageCreator
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestGuillotineCellsAndGuttersFillCanvas(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	for _, n := range []int{1, 2, 7, 50} {
+		for _, gutter := range []float64{0, 6} {
+			what := fmt.Sprintf("%d images with gutter %v", n, gutter)
+			parameters := newTestParameters()
+			pcg := PositionCalculator_Guillotine_Init()
+			pcg.p.canvasSize, pcg.p.gutter = "1200x800", gutter
+			if !pcg.ParseCustomParameters(parameters) {
+				t.Fatal("parameters rejected")
+			}
+			sizes := make([]Dims, n)
+			for i := range sizes {
+				sizes[i] = NewDims(float64(100+random.Intn(300)), float64(100+random.Intn(300)))
+			}
+			imageLayout, err := pcg.CalculatePositions(newTestLayout(parameters, sizes...))
+			if err != nil {
+				t.Fatalf("%s: %v", what, err)
+			}
+			canvas := imageLayout.CanvasSize()
+			if canvas != NewDims(1200, 800) {
+				t.Fatalf("%s: canvas %v", what, canvas)
+			}
+
+			// Widening each cell by half a gutter on every side away from the edge of the
+			// canvas takes in the gutters; the widened cells must then tile the canvas.
+			const epsilon = 1e-6
+			type rect struct{ x0, y0, x1, y1 float64 }
+			var rects []rect
+			area := 0.0
+			for _, img := range imageLayout.Images(false) {
+				pos, dims := imageLayout.PositionOf(img), imageLayout.DimensionsOf(img)
+				r := rect{pos.X(), pos.Y(), pos.X() + dims.X(), pos.Y() + dims.Y()}
+				if r.x0 < -epsilon || r.y0 < -epsilon || r.x1 > canvas.X()+epsilon || r.y1 > canvas.Y()+epsilon {
+					t.Errorf("%s: cell %v lies outside the canvas", what, r)
+				}
+				if r.x0 > epsilon {
+					r.x0 -= gutter / 2
+				}
+				if r.y0 > epsilon {
+					r.y0 -= gutter / 2
+				}
+				if r.x1 < canvas.X()-epsilon {
+					r.x1 += gutter / 2
+				}
+				if r.y1 < canvas.Y()-epsilon {
+					r.y1 += gutter / 2
+				}
+				rects = append(rects, r)
+				area += (r.x1 - r.x0) * (r.y1 - r.y0)
+			}
+			for i := range rects {
+				for j := i + 1; j < len(rects); j++ {
+					overlapX := math.Min(rects[i].x1, rects[j].x1) - math.Max(rects[i].x0, rects[j].x0)
+					overlapY := math.Min(rects[i].y1, rects[j].y1) - math.Max(rects[i].y0, rects[j].y0)
+					if overlapX > epsilon && overlapY > epsilon {
+						t.Errorf("%s: cells %v and %v overlap", what, rects[i], rects[j])
+					}
+				}
+			}
+			assertNear(t, what+": area of cells and gutters", area, canvas.X()*canvas.Y(), 1e-6*canvas.X()*canvas.Y())
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package CollageCreator
+
+import (
+	"errors"
+	"flag"
+	"math"
+	"sort"
+)
+
+const (
+	Justified_RowHeight       string = "Justified_RowHeight"
+	Justified_CanvasWidth     string = "Justified_CanvasWidth"
+	Justified_ExactOrder      string = "Justified_ExactOrder"
+	Justified_JustifyLastLine string = "Justified_JustifyLastLine"
+)
+
+// The horizontal extent of a run of images laid out in one row: at height 'h', the row is
+// 'aspect*h + absolute' wide, padding included.
+type justifiedRun struct {
+	aspect   float64
+	absolute float64
+}
+
+// Finds the height of the row holding 'run' that fills 'width' exactly, or 0 if none does.
+func (jr justifiedRun) heightFor(width float64) float64 {
+	if width <= jr.absolute || jr.aspect <= 0 {
+		return 0
+	}
+	return (width - jr.absolute) / jr.aspect
+}
+
+// Measures how each image adds to the width of a row, as 'runOneTiling' does for TileInOrder.
+func justifiedRuns(imageLayout ImageLayout, imagesInOrder []ImageIdentifier) []justifiedRun {
+	runs := make([]justifiedRun, len(imagesInOrder))
+	for i, img := range imagesInOrder {
+		imgDims := imageLayout.DimensionsOf(img)
+		imgPadding := Padding(imageLayout, img)
+		runs[i].aspect = imgDims.X() / imgDims.Y()
+		if PaddingIsRelative(imageLayout, img) {
+			runs[i].aspect += 2 * imgPadding.X() / imgDims.Y()
+		} else {
+			runs[i].absolute = 2 * imgPadding.X()
+		}
+	}
+	return runs
+}
+
+// Chooses where to break the images into rows, minimizing the total squared deviation of
+// the row heights from 'rowHeight'. Returns the index at which each row starts and the height
+// of each row. A ragged last line is not stretched beyond 'rowHeight', and so costs nothing
+// if it does not fill the width at that height.
+func breakJustifiedRows(runs []justifiedRun, width, rowHeight float64, justifyLastLine bool) (starts []int, heights []float64, err error) {
+	n := len(runs)
+	prefix := make([]justifiedRun, n+1)
+	for i, run := range runs {
+		prefix[i+1] = justifiedRun{prefix[i].aspect + run.aspect, prefix[i].absolute + run.absolute}
+	}
+	rowOf := func(i, j int) (height, cost float64) {
+		run := justifiedRun{prefix[j].aspect - prefix[i].aspect, prefix[j].absolute - prefix[i].absolute}
+		height = run.heightFor(width)
+		if height == 0 {
+			return 0, math.Inf(1)
+		}
+		if j == n && !justifyLastLine && height > rowHeight {
+			return rowHeight, 0
+		}
+		return height, (height - rowHeight) * (height - rowHeight)
+	}
+	best := make([]float64, n+1)
+	breaks := make([]int, n+1)
+	for j := 1; j <= n; j++ {
+		best[j] = math.Inf(1)
+		for i := j - 1; i >= 0; i-- {
+			height, cost := rowOf(i, j)
+			if best[i]+cost < best[j] {
+				best[j], breaks[j] = best[i]+cost, i
+			}
+			// Adding images to the start of the row only makes it lower, so once it is
+			// lower than the target, its cost only grows; once that alone is no better
+			// than the best found, no longer row can be better.
+			if height != 0 && height < rowHeight && cost >= best[j] {
+				break
+			}
+		}
+	}
+	if math.IsInf(best[n], 1) {
+		err = errors.New("padding leaves no room for images within the canvas width")
+		return
+	}
+	for j := n; j > 0; j = breaks[j] {
+		height, _ := rowOf(breaks[j], j)
+		starts = append([]int{breaks[j]}, starts...)
+		heights = append([]float64{height}, heights...)
+	}
+	return
+}
+
+func calculatePositions_Justified(imageLayout ImageLayout) (il ImageLayout, err error) {
+	parameters := imageLayout.Parameters()
+	imagesInOrder := imageLayout.Images(true)
+	if len(imagesInOrder) == 0 {
+		err = errors.New("no images to position")
+		return
+	}
+	if !parameters.OtherBool(Justified_ExactOrder) {
+		sort.SliceStable(imagesInOrder, func(i, j int) bool {
+			return imageLayout.WeightOf(imagesInOrder[i]) > imageLayout.WeightOf(imagesInOrder[j])
+		})
+	}
+
+	rowHeight := parameters.OtherFloat(Justified_RowHeight)
+	if rowHeight == 0 {
+		heights := make([]float64, len(imagesInOrder))
+		for i, img := range imagesInOrder {
+			heights[i] = imageLayout.DimensionsOf(img).Y()
+		}
+		sort.Float64s(heights)
+		rowHeight = heights[len(heights)/2]
+	}
+	runs := justifiedRuns(imageLayout, imagesInOrder)
+	width := parameters.OtherFloat(Justified_CanvasWidth)
+	if width == 0 {
+		width = parameters.MaxCanvasSize().X()
+	}
+	if width == 0 {
+		// Choose the width that gives the collage the target aspect ratio, were every row
+		// exactly the target height.
+		aspectSum := 0.0
+		for _, run := range runs {
+			aspectSum += run.aspect
+		}
+		width = math.Round(rowHeight * math.Sqrt(autoAspectRatio(imageLayout)*aspectSum))
+	}
+	width = math.Max(width, parameters.MinCanvasSize().X())
+
+	starts, heights, err := breakJustifiedRows(runs, width, rowHeight, parameters.OtherBool(Justified_JustifyLastLine))
+	if err != nil {
+		return
+	}
+	currentLayout := imageLayout.Duplicate()
+	nextLineDim := 0.0
+	for row, start := range starts {
+		end := len(imagesInOrder)
+		if row+1 < len(starts) {
+			end = starts[row+1]
+		}
+		// The images of a row are aligned with each other, behind the largest vertical padding
+		// of any of them.
+		linePadding := 0.0
+		for _, img := range imagesInOrder[start:end] {
+			imgDims := currentLayout.DimensionsOf(img)
+			newImgDims := NewDims(imgDims.X()*heights[row]/imgDims.Y(), heights[row])
+			currentLayout = resizeImage(currentLayout, img, newImgDims, func(f float64) float64 { return f })
+			linePadding = math.Max(linePadding, Padding(currentLayout, img).Y())
+		}
+		nextImageDim := 0.0
+		for _, img := range imagesInOrder[start:end] {
+			imgPadding := Padding(currentLayout, img)
+			currentLayout, _ = currentLayout.SetPosition(img, NewDims(nextImageDim+imgPadding.X(), nextLineDim+linePadding))
+			nextImageDim += currentLayout.DimensionsOf(img).X() + 2*imgPadding.X()
+		}
+		nextLineDim += heights[row] + 2*linePadding
+	}
+	canvasSize := NewDims(width, math.Max(nextLineDim, parameters.MinCanvasSize().Y()))
+	if maxDim := parameters.MaxCanvasSize(); maxDim.Y() > 0 && canvasSize.Y() > maxDim.Y() {
+		err = errors.New("justified rows are taller than the maximum canvas size")
+		return
+	}
+	currentLayout.SetCanvasSize(canvasSize)
+	parameters.ProgressMonitor().ReportPositioningSuccess()
+	il = currentLayout
+	return
+}
+
+// A PositionCalculator that places images in rows of equal width, as in a justified photo
+// gallery: each row is scaled to fill the canvas width exactly, and the rows are broken
+// where they make the row heights deviate least from a target height.
+type PositionCalculator_Justified struct {
+	p *positionCalculator_Justified_Parameters
+}
+type positionCalculator_Justified_Parameters struct {
+	rowHeight       float64
+	canvasWidth     float64
+	exactOrder      bool
+	justifyLastLine bool
+}
+
+func PositionCalculator_Justified_Init() PositionCalculator_Justified {
+	return PositionCalculator_Justified{new(positionCalculator_Justified_Parameters)}
+}
+
+func (pcj PositionCalculator_Justified) RegisterCustomParameters(parameters *Parameters) bool {
+	flag.Float64Var(&(pcj.p.rowHeight), "row-height", 0, "(Justified placement algorithm) Target height of each row (0 to use the median image height)")
+	flag.Float64Var(&(pcj.p.canvasWidth), "canvas-width", 0, "(Justified placement algorithm) Width of the canvas (0 to use the maximum canvas width, or to follow the aspect ratio)")
+	flag.BoolVar(&(pcj.p.exactOrder), "justified-exact-order", false, "(Justified placement algorithm) Put images in the collage in exact parameter order, rather than heaviest first (without importance weights, the two orders are the same)")
+	flag.BoolVar(&(pcj.p.justifyLastLine), "justify-last-line", false, "(Justified placement algorithm) Stretch the last row to the full canvas width, rather than leaving it ragged")
+	return true
+}
+
+func (pcj PositionCalculator_Justified) ParseCustomParameters(parameters *Parameters) bool {
+	if pcj.p.rowHeight < 0 {
+		parameters.ProgressMonitor().ReportMessage("-row-height value must not be negative")
+		return false
+	}
+	if pcj.p.canvasWidth < 0 {
+		parameters.ProgressMonitor().ReportMessage("-canvas-width value must not be negative")
+		return false
+	}
+	parameters.SetOther(Justified_RowHeight, pcj.p.rowHeight)
+	parameters.SetOther(Justified_CanvasWidth, pcj.p.canvasWidth)
+	parameters.SetOther(Justified_ExactOrder, pcj.p.exactOrder)
+	parameters.SetOther(Justified_JustifyLastLine, pcj.p.justifyLastLine)
+	return true
+}
+
+func (pcj PositionCalculator_Justified) CalculatePositions(imageLayout ImageLayout) (il ImageLayout, err error) {
+	il, err = calculatePositions_Justified(imageLayout)
+	return
+}
@@ -0,0 +1,97 @@
+package CollageCreator
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// The cost of breaking 'runs' into rows starting at 'starts', as 'breakJustifiedRows' measures it.
+func justifiedBreakingCost(runs []justifiedRun, starts []int, width, rowHeight float64, justifyLastLine bool) float64 {
+	cost := 0.0
+	for row, start := range starts {
+		end := len(runs)
+		if row+1 < len(starts) {
+			end = starts[row+1]
+		}
+		var run justifiedRun
+		for _, r := range runs[start:end] {
+			run.aspect += r.aspect
+			run.absolute += r.absolute
+		}
+		height := run.heightFor(width)
+		if height == 0 {
+			return math.Inf(1)
+		}
+		if end == len(runs) && !justifyLastLine && height > rowHeight {
+			height = rowHeight
+		}
+		cost += (height - rowHeight) * (height - rowHeight)
+	}
+	return cost
+}
+
+func TestBreakJustifiedRowsMatchesBruteForce(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	for trial := 0; trial < 300; trial++ {
+		n := 1 + random.Intn(8)
+		runs := make([]justifiedRun, n)
+		for i := range runs {
+			runs[i] = justifiedRun{0.3 + 2.5*random.Float64(), float64(random.Intn(3)) * 4}
+		}
+		width, rowHeight := 200+600*random.Float64(), 50+150*random.Float64()
+		justifyLastLine := trial%2 == 0
+
+		// Try every way of breaking the images into rows.
+		bruteForce := math.Inf(1)
+		for mask := 0; mask < 1<<(n-1); mask++ {
+			starts := []int{0}
+			for i := 1; i < n; i++ {
+				if mask&(1<<(i-1)) != 0 {
+					starts = append(starts, i)
+				}
+			}
+			bruteForce = math.Min(bruteForce, justifiedBreakingCost(runs, starts, width, rowHeight, justifyLastLine))
+		}
+
+		starts, heights, err := breakJustifiedRows(runs, width, rowHeight, justifyLastLine)
+		if err != nil {
+			t.Fatalf("trial %d: %v", trial, err)
+		}
+		if len(heights) != len(starts) || starts[0] != 0 {
+			t.Fatalf("trial %d: rows start at %v with heights %v", trial, starts, heights)
+		}
+		got := justifiedBreakingCost(runs, starts, width, rowHeight, justifyLastLine)
+		assertNear(t, "cost of the chosen rows", got, bruteForce, 1e-6*math.Max(1, bruteForce))
+	}
+}
+
+func TestBreakJustifiedRowsLastLine(t *testing.T) {
+	runs := []justifiedRun{{1, 0}, {1, 0}, {1, 0}, {1, 0}}
+	cases := []struct {
+		justifyLastLine bool
+		starts          []int
+		heights         []float64
+	}{
+		// A ragged last line may hold a lone image at the target height.
+		{false, []int{0, 3}, []float64{3, 3}},
+		// A justified one would stretch that image to the full width, so all four share a row.
+		{true, []int{0}, []float64{2.25}},
+	}
+	for _, c := range cases {
+		starts, heights, err := breakJustifiedRows(runs, 9, 3, c.justifyLastLine)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(starts, c.starts) || !reflect.DeepEqual(heights, c.heights) {
+			t.Errorf("justify last line %v: rows %v of heights %v, want %v of %v", c.justifyLastLine, starts, heights, c.starts, c.heights)
+		}
+	}
+}
+
+func TestBreakJustifiedRowsRejectsPaddingWiderThanCanvas(t *testing.T) {
+	if _, _, err := breakJustifiedRows([]justifiedRun{{1, 50}}, 40, 10, false); err == nil {
+		t.Error("padding wider than the canvas was accepted")
+	}
+}
@@ -0,0 +1,293 @@
+package CollageCreator
+
+import (
+	"errors"
+	"flag"
+	"math"
+	"sort"
+	"strings"
+)
+
+const (
+	Masonry_Columns     string = "Masonry_Columns"
+	Masonry_ColumnWidth string = "Masonry_ColumnWidth"
+	Masonry_LookAhead   string = "Masonry_LookAhead"
+	Masonry_Bottom      string = "Masonry_Bottom"
+)
+
+// The ways in which the bottom edge of a masonry layout may be finished.
+type MasonryBottom int
+
+const (
+	// Leave the columns at their natural heights.
+	RaggedBottom MasonryBottom = iota
+	// Crop the last image of each column to the height of the shortest column.
+	CropBottom
+	// Enlarge the last image of each column to the height of the tallest column,
+	// cropping its sides to keep it within the column.
+	StretchBottom
+)
+
+// The largest number of ways of placing the look-ahead images that will be tried for each image.
+const masonry_MaxLookAheadTries int = 1 << 14
+
+// Finds the width of an image placed in a column 'slot' wide, so that it fills the column
+// along with its padding, and its height at that width, padding included.
+func masonryImageSize(imageLayout ImageLayout, img ImageIdentifier, slot float64) (width, slotHeight float64) {
+	parameters := imageLayout.Parameters()
+	imgDims := imageLayout.DimensionsOf(img)
+	atSlot := NewDims(slot, imgDims.Y()*slot/imgDims.X())
+	slotPadding := paddingForDims(parameters, atSlot)
+	if PaddingIsRelative(imageLayout, img) {
+		width = slot * slot / (slot + 2*slotPadding.X())
+	} else {
+		width = slot - 2*slotPadding.X()
+	}
+	dims := NewDims(width, imgDims.Y()*width/imgDims.X())
+	return width, dims.Y() + 2*paddingForDims(parameters, dims).Y()
+}
+
+// Chooses the column for the next image: the shortest, or, looking ahead over the next
+// images, the one that leads to the smallest difference between the tallest and the shortest
+// column once they have all been placed.
+func chooseMasonryColumn(heights []float64, slotHeights []float64, lookAhead int) int {
+	shortest := 0
+	for c, h := range heights {
+		if h < heights[shortest] {
+			shortest = c
+		}
+	}
+	if lookAhead <= 1 || len(slotHeights) <= 1 {
+		return shortest
+	}
+	depth := int(math.Min(float64(lookAhead), float64(len(slotHeights))))
+	for depth > 1 && math.Pow(float64(len(heights)), float64(depth)) > float64(masonry_MaxLookAheadTries) {
+		depth--
+	}
+	trial := append([]float64{}, heights...)
+	bestSpread, bestTallest, bestColumn := math.Inf(1), math.Inf(1), shortest
+	var search func(i, first int)
+	search = func(i, first int) {
+		if i == depth {
+			tallest, lowest := trial[0], trial[0]
+			for _, h := range trial {
+				tallest, lowest = math.Max(tallest, h), math.Min(lowest, h)
+			}
+			if tallest-lowest < bestSpread || (tallest-lowest == bestSpread && tallest < bestTallest) {
+				bestSpread, bestTallest, bestColumn = tallest-lowest, tallest, first
+			}
+			return
+		}
+		tried := map[float64]bool{}
+		for c := range trial {
+			// Columns of equal height are interchangeable.
+			if tried[trial[c]] {
+				continue
+			}
+			tried[trial[c]] = true
+			if i == 0 {
+				first = c
+			}
+			trial[c] += slotHeights[i]
+			search(i+1, first)
+			trial[c] -= slotHeights[i]
+		}
+	}
+	search(0, shortest)
+	return bestColumn
+}
+
+// Scales and crops an image so that, along with its padding, it fills the box of the given
+// size at 'topLeft' exactly, and places it there. The padding may depend on the shape of the
+// image, so the size that fills the box is found by iteration.
+func fitMasonryImage(imageLayout ImageLayout, img ImageIdentifier, topLeft Dims, box Dims) ImageLayout {
+	parameters := imageLayout.Parameters()
+	dims := imageLayout.DimensionsOf(img)
+	for k := 0; k < 32; k++ {
+		imgPadding := paddingForDims(parameters, dims)
+		next := NewDims(box.X()-2*imgPadding.X(), math.Max(1, box.Y()-2*imgPadding.Y()))
+		converged := math.Abs(next.X()-dims.X()) < 1e-9 && math.Abs(next.Y()-dims.Y()) < 1e-9
+		dims = next
+		if converged {
+			break
+		}
+	}
+	// Enlarge the image until it covers the new shape, then crop away the excess.
+	imgDims := imageLayout.DimensionsOf(img)
+	scale := math.Max(dims.X()/imgDims.X(), dims.Y()/imgDims.Y())
+	if scale > 1 {
+		imageLayout = resizeImage(imageLayout, img, NewDims(imgDims.X()*scale, imgDims.Y()*scale), func(f float64) float64 { return f })
+	}
+	imageLayout = cropImageCentered(imageLayout, img, dims)
+	imgPadding := Padding(imageLayout, img)
+	imageLayout, _ = imageLayout.SetPosition(img, NewDims(topLeft.X()+imgPadding.X(), topLeft.Y()+imgPadding.Y()))
+	return imageLayout
+}
+
+func calculatePositions_Masonry(imageLayout ImageLayout) (il ImageLayout, err error) {
+	parameters := imageLayout.Parameters()
+	images := imageLayout.Images(false)
+	if len(images) == 0 {
+		err = errors.New("no images to position")
+		return
+	}
+	columns := parameters.OtherInt(Masonry_Columns)
+	slot := parameters.OtherFloat(Masonry_ColumnWidth)
+	maxWidth := parameters.MaxCanvasSize().X()
+	if slot == 0 && (columns == 0 || maxWidth == 0) {
+		widths := make([]float64, len(images))
+		for i, img := range images {
+			widths[i] = imageLayout.DimensionsOf(img).X()
+		}
+		sort.Float64s(widths)
+		slot = widths[len(widths)/2]
+	}
+	if columns == 0 && maxWidth > 0 {
+		columns = int(math.Max(1, math.Floor(maxWidth/slot)))
+	} else if columns == 0 {
+		// Choose the number of columns that gives the collage the target aspect ratio.
+		heightSum := 0.0
+		for _, img := range images {
+			heightSum += imageLayout.DimensionsOf(img).Y() / imageLayout.DimensionsOf(img).X()
+		}
+		columns = int(math.Max(1, math.Round(math.Sqrt(autoAspectRatio(imageLayout)*heightSum))))
+	}
+	if parameters.OtherInt(Masonry_Columns) == 0 && columns > len(images) {
+		columns = len(images)
+	}
+	if maxWidth > 0 {
+		// Fill the maximum width exactly.
+		slot = maxWidth / float64(columns)
+	}
+
+	widths := make([]float64, len(images))
+	slotHeights := make([]float64, len(images))
+	for i, img := range images {
+		widths[i], slotHeights[i] = masonryImageSize(imageLayout, img, slot)
+		if widths[i] <= 0 {
+			err = errors.New("padding leaves no room for images within the column width")
+			return
+		}
+	}
+	lookAhead := parameters.OtherInt(Masonry_LookAhead)
+	heights := make([]float64, columns)
+	lastInColumn := make([]int, columns)
+	tops := make([]float64, len(images))
+	for c := range lastInColumn {
+		lastInColumn[c] = -1
+	}
+	currentLayout := imageLayout.Duplicate()
+	for i, img := range images {
+		c := chooseMasonryColumn(heights, slotHeights[i:], lookAhead)
+		imgDims := currentLayout.DimensionsOf(img)
+		currentLayout = resizeImage(currentLayout, img, NewDims(widths[i], imgDims.Y()*widths[i]/imgDims.X()), func(f float64) float64 { return f })
+		imgPadding := Padding(currentLayout, img)
+		currentLayout, _ = currentLayout.SetPosition(img, NewDims(float64(c)*slot+imgPadding.X(), heights[c]+imgPadding.Y()))
+		tops[i] = heights[c]
+		heights[c] += slotHeights[i]
+		lastInColumn[c] = i
+	}
+
+	tallest, lowest := 0.0, math.Inf(1)
+	for c, h := range heights {
+		if lastInColumn[c] >= 0 {
+			tallest, lowest = math.Max(tallest, h), math.Min(lowest, h)
+		}
+	}
+	canvasHeight := tallest
+	bottom := RaggedBottom
+	if bottomI, valid := parameters.Other(Masonry_Bottom); valid {
+		bottom, _ = bottomI.(MasonryBottom)
+	}
+	switch bottom {
+	case CropBottom:
+		canvasHeight = lowest
+		for c, i := range lastInColumn {
+			if i < 0 || heights[c] <= lowest {
+				continue
+			}
+			// Keep at least a pixel of the image, should look-ahead have left a column far
+			// taller than the others.
+			minSlotHeight := 1 + 2*paddingForDims(parameters, NewDims(widths[i], 1)).Y()
+			cut := math.Min(heights[c]-lowest, slotHeights[i]-minSlotHeight)
+			currentLayout = fitMasonryImage(currentLayout, images[i], NewDims(float64(c)*slot, tops[i]), NewDims(slot, slotHeights[i]-cut))
+			canvasHeight = math.Max(canvasHeight, heights[c]-cut)
+		}
+	case StretchBottom:
+		for c, i := range lastInColumn {
+			if i < 0 || heights[c] >= tallest {
+				continue
+			}
+			currentLayout = fitMasonryImage(currentLayout, images[i], NewDims(float64(c)*slot, tops[i]), NewDims(slot, tallest-tops[i]))
+		}
+	}
+
+	canvasSize := NewDims(math.Max(slot*float64(columns), parameters.MinCanvasSize().X()), math.Max(canvasHeight, parameters.MinCanvasSize().Y()))
+	if maxDim := parameters.MaxCanvasSize(); maxDim.Y() > 0 && canvasSize.Y() > maxDim.Y() {
+		err = errors.New("masonry columns are taller than the maximum canvas size")
+		return
+	}
+	currentLayout.SetCanvasSize(canvasSize)
+	parameters.ProgressMonitor().ReportPositioningSuccess()
+	il = currentLayout
+	return
+}
+
+// A PositionCalculator that places images in columns of equal width, in the style of a
+// masonry grid: each image, scaled to the column width, is added to the shortest column.
+type PositionCalculator_Masonry struct {
+	p *positionCalculator_Masonry_Parameters
+}
+type positionCalculator_Masonry_Parameters struct {
+	columns     int
+	columnWidth float64
+	lookAhead   int
+	bottom      string
+}
+
+func PositionCalculator_Masonry_Init() PositionCalculator_Masonry {
+	return PositionCalculator_Masonry{new(positionCalculator_Masonry_Parameters)}
+}
+
+func (pcm PositionCalculator_Masonry) RegisterCustomParameters(parameters *Parameters) bool {
+	flag.IntVar(&(pcm.p.columns), "column-count", 0, "(Masonry placement algorithm) Number of columns (0 to follow the column width)")
+	flag.Float64Var(&(pcm.p.columnWidth), "column-width", 0, "(Masonry placement algorithm) Target width of each column (0 to use the median image width)")
+	flag.IntVar(&(pcm.p.lookAhead), "look-ahead", 0, "(Masonry placement algorithm) Number of images to look ahead when choosing a column, to even out the column heights (0 to always use the shortest column)")
+	flag.StringVar(&(pcm.p.bottom), "square-bottom", "", "(Masonry placement algorithm) Square off the bottom edge by cropping ('crop') or enlarging ('stretch') the last image of each column")
+	return true
+}
+
+func (pcm PositionCalculator_Masonry) ParseCustomParameters(parameters *Parameters) bool {
+	if pcm.p.columns < 0 {
+		parameters.ProgressMonitor().ReportMessage("-column-count value must not be negative")
+		return false
+	}
+	if pcm.p.columnWidth < 0 {
+		parameters.ProgressMonitor().ReportMessage("-column-width value must not be negative")
+		return false
+	}
+	if pcm.p.lookAhead < 0 {
+		parameters.ProgressMonitor().ReportMessage("-look-ahead value must not be negative")
+		return false
+	}
+	parameters.SetOther(Masonry_Columns, pcm.p.columns)
+	parameters.SetOther(Masonry_ColumnWidth, pcm.p.columnWidth)
+	parameters.SetOther(Masonry_LookAhead, pcm.p.lookAhead)
+	switch strings.ToLower(pcm.p.bottom) {
+	case "":
+		parameters.SetOther(Masonry_Bottom, RaggedBottom)
+	case "crop":
+		parameters.SetOther(Masonry_Bottom, CropBottom)
+	case "stretch":
+		parameters.SetOther(Masonry_Bottom, StretchBottom)
+	default:
+		parameters.ProgressMonitor().ReportMessage("-square-bottom value must be 'crop' or 'stretch'")
+		return false
+	}
+	return true
+}
+
+func (pcm PositionCalculator_Masonry) CalculatePositions(imageLayout ImageLayout) (il ImageLayout, err error) {
+	il, err = calculatePositions_Masonry(imageLayout)
+	return
+}
@@ -0,0 +1,108 @@
+package CollageCreator
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// Lays out images of the given sizes in masonry columns.
+func masonryLayout(t *testing.T, padding string, columns, lookAhead int, bottom string, sizes []Dims) (ImageLayout, *Parameters) {
+	parameters := newTestParameters()
+	parameters.SetPadding(MustParseGeometry(padding))
+	pcm := PositionCalculator_Masonry_Init()
+	pcm.p.columns, pcm.p.lookAhead, pcm.p.bottom = columns, lookAhead, bottom
+	if !pcm.ParseCustomParameters(parameters) {
+		t.Fatal("parameters rejected")
+	}
+	imageLayout, err := pcm.CalculatePositions(newTestLayout(parameters, sizes...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return imageLayout, parameters
+}
+
+// Finds the height of each column of a masonry layout, padding included, from where its
+// images lie.
+func masonryColumnHeights(imageLayout ImageLayout, columns int) []float64 {
+	slot := imageLayout.CanvasSize().X() / float64(columns)
+	heights := make([]float64, columns)
+	for _, img := range imageLayout.Images(false) {
+		imgPadding := Padding(imageLayout, img)
+		c := int((imageLayout.PositionOf(img).X() - imgPadding.X()) / slot)
+		bottom := imageLayout.PositionOf(img).Y() + imageLayout.DimensionsOf(img).Y() + imgPadding.Y()
+		heights[c] = math.Max(heights[c], bottom)
+	}
+	return heights
+}
+
+// Creates images of random sizes.
+func randomMasonrySizes(random *rand.Rand, n int) []Dims {
+	sizes := make([]Dims, n)
+	for i := range sizes {
+		sizes[i] = NewDims(float64(100+random.Intn(200)), float64(100+random.Intn(300)))
+	}
+	return sizes
+}
+
+func TestMasonrySquareBottomEvensColumns(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	for _, bottom := range []string{"crop", "stretch"} {
+		for _, padding := range []string{"0x0", "4x4", "2x2%"} {
+			for trial := 0; trial < 10; trial++ {
+				columns := 2 + trial%3
+				imageLayout, _ := masonryLayout(t, padding, columns, 0, bottom, randomMasonrySizes(random, 3*columns+trial))
+				canvasHeight := imageLayout.CanvasSize().Y()
+				for c, h := range masonryColumnHeights(imageLayout, columns) {
+					assertNear(t, fmt.Sprintf("%s column %d with padding %s", bottom, c, padding), h, canvasHeight, 1e-6)
+				}
+			}
+		}
+	}
+}
+
+// Places images of the given heights, column width and padding included, in columns,
+// and returns the difference between the tallest and the shortest column.
+func masonrySpread(columns int, slotHeights []float64, lookAhead int) float64 {
+	heights := make([]float64, columns)
+	for i := range slotHeights {
+		heights[chooseMasonryColumn(heights, slotHeights[i:], lookAhead)] += slotHeights[i]
+	}
+	tallest, lowest := heights[0], heights[0]
+	for _, h := range heights {
+		tallest, lowest = math.Max(tallest, h), math.Min(lowest, h)
+	}
+	return tallest - lowest
+}
+
+func TestMasonryLookAheadNoWorseThanShortestColumn(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	for trial := 0; trial < 200; trial++ {
+		columns := 2 + random.Intn(3)
+		slotHeights := make([]float64, 4+random.Intn(5))
+		for i := range slotHeights {
+			slotHeights[i] = 50 + 300*random.Float64()
+		}
+		// Looking ahead over every remaining image, the search is exhaustive.
+		greedy := masonrySpread(columns, slotHeights, 0)
+		lookAhead := masonrySpread(columns, slotHeights, len(slotHeights))
+		if lookAhead > greedy+1e-9 {
+			t.Errorf("trial %d: look-ahead left columns %v apart, the shortest column %v", trial, lookAhead, greedy)
+		}
+	}
+	// The columns of a full layout agree.
+	sizes := randomMasonrySizes(random, 8)
+	greedyLayout, _ := masonryLayout(t, "0x0", 3, 0, "", sizes)
+	lookAheadLayout, _ := masonryLayout(t, "0x0", 3, 8, "", sizes)
+	spread := func(heights []float64) float64 {
+		tallest, lowest := heights[0], heights[0]
+		for _, h := range heights {
+			tallest, lowest = math.Max(tallest, h), math.Min(lowest, h)
+		}
+		return tallest - lowest
+	}
+	if greedy, lookAhead := spread(masonryColumnHeights(greedyLayout, 3)), spread(masonryColumnHeights(lookAheadLayout, 3)); lookAhead > greedy+1e-6 {
+		t.Errorf("look-ahead left columns %v apart, the shortest column %v", lookAhead, greedy)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"flag"
 	"fmt"
 	"math"
+	"sort"
 )
 
 const (
@@ -12,6 +13,10 @@ const (
 	TileInOrder_Columns    string = "TileInOrder_Columns"
 )
 
+// The fraction of the line length that must be filled before a line may be broken early
+// to keep images of different weights on different lines.
+const tileInOrder_WeightBreakFraction float64 = 0.5
+
 type badnessComparator interface {
 	hasAspectRatio() bool
 	prioritizeAspectRatio() bool
@@ -61,6 +66,11 @@ type tileLine struct {
 	images   []ImageIdentifier
 	fixedDim float64
 	startsAt float64
+	// The heaviest weight of any image on the line.
+	weight float64
+	// At height 'h', the line is 'aspect*h + absolutePadding' long.
+	aspect          float64
+	absolutePadding float64
 }
 
 type tileLayout struct {
@@ -100,6 +110,9 @@ func (tl *tileLayout) append(line tileLine) {
 // 	tl.lines = newLines
 // }
 
+// Sizes and positions the images of one line. A cropped image keeps its cropped aspect ratio:
+// it is resized with 'resizeImage', which scales its crop along with it, rather than given a
+// bare scaling geometry, which would change the aspect ratio of the crop.
 func finalizeTiling_line(imageLayout ImageLayout, line tileLine, nextLineDim float64, badness *tileInOrder_Badness, fixedDim, varDim int) (il ImageLayout, newLinePosition float64, err error) {
 	currentLayout := imageLayout
 	nextImageDim := line.startsAt
@@ -108,8 +121,7 @@ func finalizeTiling_line(imageLayout ImageLayout, line tileLine, nextLineDim flo
 		newImgDims := NewDims(0, 0)
 		newImgDims.SetDim(fixedDim, imgDims.Dim(fixedDim)*line.fixedDim/imgDims.Dim(varDim))
 		newImgDims.SetDim(varDim, line.fixedDim)
-		newScaling := MustParseGeometry(fmt.Sprintf("%fx%f!", newImgDims.X(), newImgDims.Y()))
-		currentLayout, _ = currentLayout.SetScaling(img, newScaling)
+		currentLayout = resizeImage(currentLayout, img, newImgDims, func(f float64) float64 { return f })
 		imgPadding := Padding(currentLayout, img)
 		pos := NewDims(0, 0)
 		pos.SetDim(fixedDim, nextImageDim+imgPadding.Dim(fixedDim))
@@ -135,6 +147,30 @@ func finalizeTiling(imageLayout ImageLayout, tl tileLayout, badness *tileInOrder
 	return
 }
 
+// Gives each line a height share that follows its weight: no line is made taller than a
+// heavier line, scaled by the square root of the ratio of their weights, as the images'
+// areas are. A line so reduced is centred.
+func capLineHeightsByWeight(lines []tileLine, maxFixedDim float64) {
+	byWeight := make([]int, len(lines))
+	for l := range byWeight {
+		byWeight[l] = l
+	}
+	sort.SliceStable(byWeight, func(a, b int) bool { return lines[byWeight[a]].weight > lines[byWeight[b]].weight })
+	for a, l := range byWeight {
+		line := &lines[l]
+		for _, h := range byWeight[:a] {
+			heavier := lines[h]
+			if heavier.weight <= line.weight {
+				break
+			}
+			if limit := heavier.fixedDim * math.Sqrt(line.weight/heavier.weight); line.fixedDim > limit {
+				line.fixedDim = limit
+				line.startsAt = (maxFixedDim - line.aspect*line.fixedDim - line.absolutePadding) / 2.0
+			}
+		}
+	}
+}
+
 func runOneTiling(imageLayout ImageLayout, imagesInOrder []ImageIdentifier, dim Dims) (il ImageLayout, badness tileInOrder_Badness, err error) {
 	parameters := imageLayout.Parameters()
 	aspectRatio, _ := parameters.AspectRatio()
@@ -160,7 +196,36 @@ func runOneTiling(imageLayout ImageLayout, imagesInOrder []ImageIdentifier, dim
 	badness = tileInOrder_Badness{emptySpace: -1, scaledownSum: 0.0, aspectRatioSkew: 0.0}
 
 	tl := tileLayout{lines: make([]tileLine, 0, len(imagesInOrder)), fixedDim: 0}
+	// Closes the current line before image 'end'. A full line is scaled to fill the line
+	// length exactly; any other is left at the height of its shortest image, and centred.
+	closeLine := func(end int, full bool) {
+		line := tileLine{images: imagesInOrder[currentLineStartIndex:end], aspect: imagesAspect + relativePaddingAspect, absolutePadding: absolutePadding}
+		if full {
+			line.fixedDim = (maxFixedDim - absolutePadding) / line.aspect
+		} else {
+			line.fixedDim = currentMinVarDim
+			line.startsAt = (maxFixedDim - line.aspect*line.fixedDim - absolutePadding) / 2.0
+		}
+		for _, jmg := range line.images {
+			line.weight = math.Max(line.weight, currentLayout.WeightOf(jmg))
+		}
+		tl.append(line)
+		currentMinVarDim = 0.0
+		currentLineStartIndex = end
+		imagesAspect = 0.0
+		relativePaddingAspect = 0.0
+		absolutePadding = 0.0
+	}
 	for i, img := range imagesInOrder {
+		// Where the line is already well filled, start a new one rather than
+		// put images of different weights on the same line, so that the
+		// line height can reflect the weight of the images on it.
+		if i > currentLineStartIndex && currentLayout.WeightOf(img) != currentLayout.WeightOf(imagesInOrder[i-1]) {
+			currentLineWidth := (imagesAspect+relativePaddingAspect)*currentMinVarDim + absolutePadding
+			if currentLineWidth >= tileInOrder_WeightBreakFraction*maxFixedDim {
+				closeLine(i, false)
+			}
+		}
 		imgDims := currentLayout.DimensionsOf(img)
 		imgPadding := Padding(currentLayout, img)
 		if currentMinVarDim == 0 || imgDims.Dim(varDim) < currentMinVarDim {
@@ -174,34 +239,21 @@ func runOneTiling(imageLayout ImageLayout, imagesInOrder []ImageIdentifier, dim
 		}
 		currentLineWidth := (imagesAspect+relativePaddingAspect)*currentMinVarDim + absolutePadding
 		if currentLineWidth >= maxFixedDim {
-			currentMinVarDim = (maxFixedDim - absolutePadding) / (imagesAspect + relativePaddingAspect)
-			line := tileLine{images: imagesInOrder[currentLineStartIndex : i+1], fixedDim: currentMinVarDim, startsAt: 0}
-			tl.append(line)
-			for _, jmg := range line.images {
-				badness.scaledownSum += (currentLayout.DimensionsOf(jmg).Dim(varDim) / line.fixedDim) - 1.0
-			}
-			currentMinVarDim = 0.0
-			currentLineStartIndex = i + 1
-			imagesAspect = 0.0
-			relativePaddingAspect = 0.0
-			absolutePadding = 0.0
+			closeLine(i+1, true)
 		}
 	}
 	if currentLineStartIndex != len(imagesInOrder) {
-		currentLineWidth := (imagesAspect+relativePaddingAspect)*currentMinVarDim + absolutePadding
-		badness.emptySpace = maxFixedDim - currentLineWidth
-		centering := (maxFixedDim - currentLineWidth) / 2.0
-		line := tileLine{images: imagesInOrder[currentLineStartIndex:], fixedDim: currentMinVarDim, startsAt: centering}
-		tl.append(line)
+		closeLine(len(imagesInOrder), false)
+	}
+	capLineHeightsByWeight(tl.lines, maxFixedDim)
+	badness.emptySpace = 0
+	tl.fixedDim = 0
+	for _, line := range tl.lines {
+		badness.emptySpace += 2 * line.startsAt
+		tl.fixedDim += line.fixedDim
 		for _, jmg := range line.images {
 			badness.scaledownSum += (currentLayout.DimensionsOf(jmg).Dim(varDim) / line.fixedDim) - 1.0
 		}
-		currentMinVarDim = 0
-		imagesAspect = 0.0
-		relativePaddingAspect = 0.0
-		absolutePadding = 0.0
-	} else {
-		badness.emptySpace = 0
 	}
 
 	currentLayout, currentLinePosition, _ = finalizeTiling(currentLayout, tl, &badness, fixedDim, varDim)
@@ -433,18 +485,13 @@ func calculatePositions_TileInOrder(imageLayout ImageLayout) (il ImageLayout, er
 	} else {
 		imagesInOrder = imageLayout.Images(true)
 		IISBy(func(lhs, rhs *ImageIdentifier) bool {
+			if imageLayout.WeightOf(*lhs) != imageLayout.WeightOf(*rhs) {
+				return imageLayout.WeightOf(*lhs) > imageLayout.WeightOf(*rhs)
+			}
 			return (imageLayout.DimensionsOf(*lhs).Dim(fixedDim) < imageLayout.DimensionsOf(*rhs).Dim(fixedDim))
 		}).Sort(imagesInOrder)
 	}
 
-	// TODO: Allow cropping.
-	for _, img := range imagesInOrder {
-		if imageLayout.CroppingOf(img).HasSize() || imageLayout.CroppingOf(img).HasOffset() {
-			il = imageLayout
-			err = errors.New("TileInOrder does not support cropping")
-			return
-		}
-	}
 	bestBadness = tileInOrder_Badness{emptySpace: math.Inf(0), scaledownSum: math.Inf(0), aspectRatioSkew: math.Inf(0)}
 	if !bComp.hasAspectRatio() {
 		_, _, err = findMinimumByBisection(imageLayout, imagesInOrder, minDim, maxDim, bComp, &bestBadness, &bestSoFar, fixedDim)
@@ -0,0 +1,92 @@
+package CollageCreator
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestTileInOrderHeavierLinesAreNotShorter(t *testing.T) {
+	aspects := []float64{1.5, 0.75, 1, 1.33, 0.66, 1.5, 1, 0.8, 1.2, 2, 0.7, 1.5, 1, 1.25}
+	for heavy := 1; heavy <= 6; heavy++ {
+		for medium := 1; medium <= 5; medium++ {
+			for _, aspectRatio := range []string{"1x1", "4x3", "3x1"} {
+				weights := []float64{}
+				for i := 0; i < heavy; i++ {
+					weights = append(weights, 4)
+				}
+				for i := 0; i < medium; i++ {
+					weights = append(weights, 2)
+				}
+				for i := 0; i < 6; i++ {
+					weights = append(weights, 1)
+				}
+				t.Run(fmt.Sprintf("%d-%d-%s", heavy, medium, aspectRatio), func(t *testing.T) {
+					checkTileInOrderWeights(t, weights, aspects, aspectRatio)
+				})
+			}
+		}
+	}
+}
+
+func checkTileInOrderWeights(t *testing.T, weights, aspects []float64, aspectRatio string) {
+	parameters := newTestParameters()
+	parameters.SetAspectRatio(MustParseGeometry(aspectRatio))
+	calculator := PositionCalculator_TileInOrder_Init()
+	calculator.ParseCustomParameters(parameters)
+	sizes := make([]Dims, len(weights))
+	for i, weight := range weights {
+		height := 200 * math.Sqrt(weight)
+		sizes[i] = NewDims(math.Round(height*aspects[i%len(aspects)]), math.Round(height))
+	}
+	imageLayout := newTestLayout(parameters, sizes...)
+	for i, weight := range weights {
+		imageLayout = imageLayout.SetWeight(ImageIdentifier(i), weight)
+	}
+	il, err := calculator.CalculatePositions(imageLayout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, img := range il.Images(false) {
+		for _, jmg := range il.Images(false) {
+			if il.WeightOf(img) > il.WeightOf(jmg) && il.DimensionsOf(img).Y() < il.DimensionsOf(jmg).Y()-1e-6 {
+				t.Fatalf("image of weight %v is %v high, lower than image of weight %v at %v",
+					il.WeightOf(img), il.DimensionsOf(img).Y(), il.WeightOf(jmg), il.DimensionsOf(jmg).Y())
+			}
+		}
+	}
+}
+
+func TestTileInOrderTilesCroppedImagesAtCroppedAspect(t *testing.T) {
+	parameters := newTestParameters()
+	parameters.SetAspectRatio(MustParseGeometry("3x1"))
+	calculator := PositionCalculator_TileInOrder_Init()
+	calculator.ParseCustomParameters(parameters)
+	imageLayout := newTestLayout(parameters, NewDims(400, 300), NewDims(400, 300), NewDims(400, 300))
+	for _, img := range imageLayout.Images(false) {
+		imageLayout.SetScaling(img, EmptyGeometry())
+		imageLayout.SetCropping(img, EmptyGeometry())
+	}
+	cropped := imageLayout.Images(false)[1]
+	imageLayout.SetCropping(cropped, MustParseGeometry("150x300+100+0"))
+	il, err := calculator.CalculatePositions(imageLayout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, img := range il.Images(false) {
+		dims := il.DimensionsOf(img)
+		want := 4.0 / 3
+		if img == cropped {
+			want = 0.5
+		}
+		assertNear(t, fmt.Sprintf("aspect ratio of image %d", img), dims.X()/dims.Y(), want, 1e-6)
+		original := il.ImageInfoOf(img).DimensionsOf()
+		if shown := ScaleAndCrop(original, il.CroppingOf(img), il.ScalingOf(img)); shown != dims {
+			t.Errorf("image %d: geometries show %v, but it is laid out at %v", img, shown, dims)
+		}
+	}
+	// The crop still shows the same part of the image, scaled with it.
+	scaled := il.ScalingOf(cropped).Scale(NewDims(400, 300))
+	offset := il.CroppingOf(cropped).Offset(scaled)
+	assertNear(t, "crop offset as a fraction of the width", offset.X()/scaled.X(), 0.25, 1e-6)
+}
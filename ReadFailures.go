@@ -0,0 +1,127 @@
+// This file contains auxiliary methods for reading input images tolerantly:
+// when unreadable images are to be skipped, each image that cannot be opened
+// or decoded is recorded rather than ending the run. Images that fail while
+// they are read are left out of the layout; those that fail only when their
+// pixel data is first needed, at rendering time, are drawn as a placeholder
+// tile. The failures are summarized once the collage has been created.
+package CollageCreator
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sync"
+)
+
+const (
+	Raster_SkipUnreadable string = "Raster_SkipUnreadable"
+	Raster_ReadFailures   string = "Raster_ReadFailures"
+)
+
+// An input image that could not be read, and why.
+type ReadFailure struct {
+	FileName string
+	Err      error
+}
+
+// A record of the input images that could not be read. It is safe for concurrent use.
+type ReadFailureLog struct {
+	mutex    sync.Mutex
+	failures []ReadFailure
+}
+
+// Records that an image could not be read. Only the first failure of each image is kept.
+func (rfl *ReadFailureLog) Record(fileName string, err error) {
+	rfl.mutex.Lock()
+	defer rfl.mutex.Unlock()
+	for _, failure := range rfl.failures {
+		if failure.FileName == fileName {
+			return
+		}
+	}
+	rfl.failures = append(rfl.failures, ReadFailure{fileName, err})
+}
+
+// Gets the failures recorded so far, in the order in which they occurred.
+func (rfl *ReadFailureLog) Failures() []ReadFailure {
+	rfl.mutex.Lock()
+	defer rfl.mutex.Unlock()
+	return append([]ReadFailure{}, rfl.failures...)
+}
+
+// Gets the log of read failures kept in the parameters, or nil if unreadable images
+// are not being skipped.
+func readFailureLogOf(parameters *Parameters) *ReadFailureLog {
+	if failuresI, valid := parameters.Other(Raster_ReadFailures); valid {
+		failures, _ := failuresI.(*ReadFailureLog)
+		return failures
+	}
+	return nil
+}
+
+// Starts a log of read failures in the parameters, if unreadable images are to be skipped,
+// and returns it; a log already started, as by an InputImageReader that wraps another, is kept
+// so that the failures of both are reported together. Returns nil if unreadable images are not
+// to be skipped.
+func startReadFailureLog(parameters *Parameters) *ReadFailureLog {
+	if skipI, valid := parameters.Other(Raster_SkipUnreadable); valid {
+		if skip, ok := skipI.(bool); ok && skip {
+			if failures := readFailureLogOf(parameters); failures != nil {
+				return failures
+			}
+			failures := new(ReadFailureLog)
+			parameters.SetOther(Raster_ReadFailures, failures)
+			return failures
+		}
+	}
+	return nil
+}
+
+// Reports a summary of the input images that could not be read, if any.
+func ReportReadFailures(parameters *Parameters) {
+	failures := readFailureLogOf(parameters)
+	if failures == nil {
+		return
+	}
+	recorded := failures.Failures()
+	if len(recorded) == 0 {
+		return
+	}
+	msg := "1 input image could not be read:"
+	if len(recorded) > 1 {
+		msg = fmt.Sprintf("%d input images could not be read:", len(recorded))
+	}
+	for _, failure := range recorded {
+		msg += fmt.Sprintf("\n  %s: %s", failure.FileName, failure.Err)
+	}
+	parameters.ProgressMonitor().ReportMessage(msg)
+}
+
+// Creates a tile to stand in for an image that could not be decoded: a grey
+// rectangle crossed by its diagonals.
+func unreadableImageTile(dims Dims) image.Image {
+	w, h := toIntP(dims.X()), toIntP(dims.Y())
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+	rv := image.NewNRGBA(image.Rect(0, 0, w, h))
+	background, line := color.NRGBA{160, 160, 160, 255}, color.NRGBA{96, 96, 96, 255}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			rv.SetNRGBA(x, y, background)
+		}
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			u, v := float64(x)/float64(w), float64(y)/float64(h)
+			if math.Abs(u-v) <= 0.01 || math.Abs(u+v-1) <= 0.01 {
+				rv.SetNRGBA(x, y, line)
+			}
+		}
+	}
+	return rv
+}
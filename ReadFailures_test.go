@@ -0,0 +1,137 @@
+package CollageCreator
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReportReadFailures(t *testing.T) {
+	cases := []struct {
+		failures []ReadFailure
+		want     string
+	}{
+		{[]ReadFailure{{"a.png", errors.New("truncated")}},
+			"1 input image could not be read:\n  a.png: truncated"},
+		{[]ReadFailure{{"a.png", errors.New("truncated")}, {"b.jpg", errors.New("unknown format")}},
+			"2 input images could not be read:\n  a.png: truncated\n  b.jpg: unknown format"},
+	}
+	for _, c := range cases {
+		var messages []string
+		parameters := newRecordingTestParameters(&messages)
+		failures := &ReadFailureLog{}
+		for _, failure := range c.failures {
+			failures.Record(failure.FileName, failure.Err)
+		}
+		parameters.SetOther(Raster_ReadFailures, failures)
+		ReportReadFailures(parameters)
+		if len(messages) != 1 || messages[0] != c.want {
+			t.Errorf("reported %q, want %q", messages, c.want)
+		}
+	}
+}
+
+// Writes a readable PNG image, one whose header is readable but whose pixel data is
+// truncated, and a file that is not an image at all, returning their names.
+func writeUnreadableTestFiles(t *testing.T) (good, truncated, broken string) {
+	dir := t.TempDir()
+	good, truncated, broken = filepath.Join(dir, "good.png"), filepath.Join(dir, "truncated.png"), filepath.Join(dir, "broken.png")
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, image.NewGray(image.Rect(0, 0, 32, 32))); err != nil {
+		t.Fatal(err)
+	}
+	for name, contents := range map[string][]byte{good: encoded.Bytes(), truncated: truncatedPNG(t), broken: []byte("not an image")} {
+		if err := os.WriteFile(name, contents, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return
+}
+
+// Creates parameters for reading the given files one at a time, without preloading them.
+func newRasterTestParameters(messages *[]string, skip bool, files ...string) *Parameters {
+	parameters := newRecordingTestParameters(messages)
+	parameters.SetInFiles(files)
+	parameters.SetOther(Raster_PreloadImages, false)
+	parameters.SetOther(Raster_Workers, 1)
+	parameters.SetOther(Raster_SkipUnreadable, skip)
+	return parameters
+}
+
+func TestSkipUnreadableImages(t *testing.T) {
+	good, truncated, broken := writeUnreadableTestFiles(t)
+	var messages []string
+	parameters := newRasterTestParameters(&messages, true, good, broken, truncated)
+	imageLayout, err := InputImageReader_Raster_Init().ReadInputImages(parameters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, img := range imageLayout.Images(false) {
+		names = append(names, imageLayout.ImageInfoOf(img).FileName())
+	}
+	if !reflect.DeepEqual(names, []string{good, truncated}) {
+		t.Fatalf("read %v, want the file that is not an image left out", names)
+	}
+
+	// The truncated image fails only when it is rendered, and is drawn as a placeholder.
+	for i, img := range imageLayout.Images(false) {
+		imageLayout.SetCropping(img, EmptyGeometry())
+		imageLayout.SetScaling(img, EmptyGeometry())
+		imageLayout.SetPosition(img, NewDims(float64(64*(1-i)), 0))
+	}
+	imageLayout.SetCanvasSize(NewDims(96, 64))
+	placeholder := unreadableImageTile(NewDims(64, 64))
+	collage, err := createCollageImage(imageLayout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []image.Point{{0, 0}, {32, 32}, {5, 40}} {
+		if got, want := color.NRGBAModel.Convert(collage.At(p.X, p.Y)), placeholder.At(p.X, p.Y); got != want {
+			t.Errorf("rendered %v at %v, want the placeholder's %v", got, p, want)
+		}
+	}
+	var failed []string
+	for _, failure := range readFailureLogOf(parameters).Failures() {
+		failed = append(failed, failure.FileName)
+	}
+	if !reflect.DeepEqual(failed, []string{broken, truncated}) {
+		t.Errorf("recorded failures of %v, want %v", failed, []string{broken, truncated})
+	}
+}
+
+func TestUnreadableImagesWithoutSkipping(t *testing.T) {
+	good, truncated, broken := writeUnreadableTestFiles(t)
+	var messages []string
+	parameters := newRasterTestParameters(&messages, false, good, broken, truncated)
+	if _, err := InputImageReader_Raster_Init().ReadInputImages(parameters); err == nil || !strings.Contains(err.Error(), broken) {
+		t.Errorf("reading gave error %v, want one naming %s", err, broken)
+	}
+	if readFailureLogOf(parameters) != nil {
+		t.Error("a log of read failures was kept without -skip-unreadable")
+	}
+
+	// Decoding the truncated image at rendering time still ends the run; check that in a
+	// separate process.
+	if os.Getenv("COLLAGE_TEST_DECODE_TRUNCATED") == "1" {
+		imageLayout, err := InputImageReader_Raster_Init().ReadInputImages(newRasterTestParameters(&messages, false, truncated))
+		if err == nil {
+			imageLayout.ImageInfoOf(imageLayout.Images(false)[0]).ImageData()
+		}
+		return
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestUnreadableImagesWithoutSkipping$")
+	cmd.Env = append(os.Environ(), "COLLAGE_TEST_DECODE_TRUNCATED=1")
+	output, err := cmd.CombinedOutput()
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.Success() || !strings.Contains(string(output), "truncated.png") {
+		t.Errorf("decoding a truncated image without -skip-unreadable gave %v:\n%s", err, output)
+	}
+}
@@ -0,0 +1,131 @@
+// This file contains auxiliary methods for reading per-image metadata kept
+// outside the pixel data: "sidecar" files stored next to an image, and XMP
+// packets either in such sidecars or embedded in the image file itself.
+package CollageCreator
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Finds a sidecar file for the given image with one of the given extensions, looking
+// both for the image's full name plus the extension (e.g., 'a.jpg.json') and for its
+// name with the extension replaced (e.g., 'a.json'). Returns "" if none exists.
+func findSidecar(fileName string, exts ...string) string {
+	base := strings.TrimSuffix(fileName, filepath.Ext(fileName))
+	for _, ext := range exts {
+		for _, candidate := range []string{fileName + ext, base + ext} {
+			if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+				return candidate
+			}
+		}
+	}
+	return ""
+}
+
+// How much of an image file other than a JPEG file is searched for an embedded XMP packet.
+const xmpScanLimit int = 64 << 10
+
+// The signature that begins a JPEG APP1 segment holding an XMP packet.
+const xmpJPEGSignature string = "http://ns.adobe.com/xap/1.0/\x00"
+
+// Reads the XMP packet describing the given image, from a '.xmp' sidecar file if one
+// exists and otherwise from the image file itself. Returns false if there is none.
+func readXMPPacket(fileName string) (packet []byte, found bool) {
+	if sidecar := findSidecar(fileName, ".xmp", ".XMP"); sidecar != "" {
+		contents, err := os.ReadFile(sidecar)
+		if err == nil {
+			return contents, true
+		}
+	}
+	reader, closer, err := openImageSource(fileName)
+	if err != nil {
+		return nil, false
+	}
+	defer closer()
+	return findEmbeddedXMP(reader)
+}
+
+// Finds the XMP packet embedded in an image file. Only the APP1 segments of a JPEG file are
+// searched, and only the first 'xmpScanLimit' bytes of any other file, so that the pixel data
+// is not read.
+func findEmbeddedXMP(r io.ReaderAt) (packet []byte, found bool) {
+	header := make([]byte, 4)
+	if _, err := r.ReadAt(header, 0); err == nil && header[0] == 0xff && header[1] == 0xd8 {
+		for pos := int64(2); ; {
+			if _, err := r.ReadAt(header, pos); err != nil || header[0] != 0xff {
+				return
+			}
+			marker, length := header[1], int64(binary.BigEndian.Uint16(header[2:4]))
+			if marker == 0xda || marker == 0xd9 {
+				// Start of scan or end of image: no more metadata
+				return
+			}
+			if marker == 0xe1 && length > int64(2+len(xmpJPEGSignature)) {
+				segment := make([]byte, length-2)
+				if _, err := r.ReadAt(segment, pos+4); err == nil && bytes.HasPrefix(segment, []byte(xmpJPEGSignature)) {
+					return extractXMPPacket(segment[len(xmpJPEGSignature):])
+				}
+			}
+			pos += 2 + length
+		}
+	}
+	contents := make([]byte, xmpScanLimit)
+	n, _ := r.ReadAt(contents, 0)
+	return extractXMPPacket(contents[:n])
+}
+
+// Extracts the 'x:xmpmeta' element from data holding an XMP packet.
+func extractXMPPacket(data []byte) (packet []byte, found bool) {
+	start := bytes.Index(data, []byte("<x:xmpmeta"))
+	if start < 0 {
+		return nil, false
+	}
+	end := bytes.Index(data[start:], []byte("</x:xmpmeta>"))
+	if end < 0 {
+		return nil, false
+	}
+	return data[start : start+end+len("</x:xmpmeta>")], true
+}
+
+// Collects the simple properties in an XMP packet, keyed by local name (without
+// namespace prefix). Properties may be given either as attributes of an
+// 'rdf:Description' element or as elements containing only text.
+func xmpProperties(packet []byte) map[string]string {
+	rv := map[string]string{}
+	decoder := xml.NewDecoder(bytes.NewReader(packet))
+	var current string
+	var text strings.Builder
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			for _, attr := range token.Attr {
+				if attr.Name.Space != "xmlns" && attr.Name.Local != "about" {
+					rv[attr.Name.Local] = attr.Value
+				}
+			}
+			current = token.Name.Local
+			text.Reset()
+		case xml.CharData:
+			text.Write(token)
+		case xml.EndElement:
+			if token.Name.Local == current {
+				if value := strings.TrimSpace(text.String()); value != "" {
+					rv[current] = value
+				}
+			}
+			current = ""
+			text.Reset()
+		}
+	}
+	return rv
+}
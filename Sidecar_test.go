@@ -0,0 +1,65 @@
+package CollageCreator
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testXMPPacket = `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">` +
+	`<rdf:Description xmlns:fp="http://example.com/fp/" fp:FocalPointX="0.25" fp:FocalPointY="0.5"/></rdf:RDF></x:xmpmeta>`
+
+// Encodes a JPEG image with the given data inserted as an APP1 segment, if any, and appended
+// after the end of the image.
+func jpegWithXMP(t *testing.T, app1, trailer []byte) []byte {
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, image.NewGray(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatal(err)
+	}
+	data := encoded.Bytes()
+	rv := append([]byte{}, data[:2]...)
+	if app1 != nil {
+		rv = append(rv, 0xFF, 0xE1, byte((len(app1)+2)>>8), byte(len(app1)+2))
+		rv = append(rv, app1...)
+	}
+	return append(append(rv, data[2:]...), trailer...)
+}
+
+func TestReadXMPPacket(t *testing.T) {
+	dir := t.TempDir()
+	beyondLimit := append(append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, xmpScanLimit)...), testXMPPacket...)
+	cases := []struct {
+		name  string
+		data  []byte
+		found bool
+	}{
+		{"in.jpg", jpegWithXMP(t, []byte(xmpJPEGSignature+testXMPPacket), nil), true},
+		{"after-image.jpg", jpegWithXMP(t, nil, []byte(testXMPPacket)), false},
+		{"unsigned-app1.jpg", jpegWithXMP(t, []byte("Other\x00"+testXMPPacket), nil), false},
+		{"near.png", append([]byte("\x89PNG\r\n\x1a\n"), testXMPPacket...), true},
+		{"far.png", beyondLimit, false},
+	}
+	for _, c := range cases {
+		fileName := filepath.Join(dir, c.name)
+		if err := os.WriteFile(fileName, c.data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		packet, found := readXMPPacket(fileName)
+		if found != c.found {
+			t.Errorf("%s: found a packet: %v, want %v", c.name, found, c.found)
+		} else if found && string(packet) != testXMPPacket {
+			t.Errorf("%s: read packet %q", c.name, packet)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "far.xmp"), []byte(testXMPPacket), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if packet, found := readXMPPacket(filepath.Join(dir, "far.png")); !found || !strings.Contains(string(packet), "FocalPointX") {
+		t.Errorf("a '.xmp' sidecar was not read: %q", packet)
+	}
+}
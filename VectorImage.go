@@ -0,0 +1,158 @@
+// This file contains auxiliary methods that read SVG files as input images.
+// Only their intrinsic dimensions are read, from the 'width', 'height', and
+// 'viewBox' attributes of the root element; the SVG renderer links to them as
+// it does to raster images, but they cannot be rasterized.
+package CollageCreator
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Implemented by an 'ImageInfo' for a vector image, whose 'ImageData' is not an 'image.Image'.
+type VectorImageInfo interface {
+	ImageInfo
+	// Names the format of the vector image (e.g. 'SVG').
+	VectorFormat() string
+}
+
+// Stands in for the pixel data of a vector image, which is not read.
+type VectorImageData struct {
+	FileName string
+}
+
+// An ImageInfo implementation for an SVG image, storing only its filename and intrinsic dimensions.
+type ImageInfo_vector struct {
+	id       ImageIdentifier
+	fileName string
+	dims     Dims
+}
+
+func (iiv ImageInfo_vector) ImageId() ImageIdentifier {
+	return iiv.id
+}
+
+func (iiv ImageInfo_vector) FileName() string {
+	return iiv.fileName
+}
+
+func (iiv ImageInfo_vector) DimensionsOf() Dims {
+	return iiv.dims
+}
+
+func (iiv ImageInfo_vector) ImageData() interface{} {
+	return VectorImageData{iiv.fileName}
+}
+
+func (iiv ImageInfo_vector) VectorFormat() string {
+	return "SVG"
+}
+
+// Determines whether an image is a vector image.
+func IsVectorImage(info ImageInfo) bool {
+	_, ok := UnwrapImageInfo(info).(VectorImageInfo)
+	return ok
+}
+
+// The number of pixels in each of the absolute units of length that SVG allows.
+var svgUnits = map[string]float64{
+	"": 1, "px": 1, "pt": 4.0 / 3, "pc": 16, "mm": 96 / 25.4, "cm": 96 / 2.54, "in": 96, "em": 16, "ex": 8,
+}
+
+// Parses an SVG length into pixels. Returns false if it is absent, relative (a percentage),
+// or malformed.
+func parseSVGLength(arg string) (length float64, valid bool) {
+	arg = strings.TrimSpace(arg)
+	i := len(arg)
+	for i > 0 && (arg[i-1] >= 'a' && arg[i-1] <= 'z' || arg[i-1] == '%') {
+		i--
+	}
+	factor, known := svgUnits[arg[i:]]
+	if !known {
+		return 0, false
+	}
+	value, err := strconv.ParseFloat(arg[:i], 64)
+	if err != nil || value <= 0 {
+		return 0, false
+	}
+	return value * factor, true
+}
+
+// Reads the intrinsic dimensions of an SVG image from its root element. Where only one of
+// 'width' and 'height' is given, the other follows from the aspect ratio of 'viewBox';
+// where neither is, those of 'viewBox' are used.
+func ReadSVGDimensions(r io.Reader) (dims Dims, err error) {
+	decoder := xml.NewDecoder(r)
+	decoder.Strict = false
+	for {
+		var token xml.Token
+		if token, err = decoder.Token(); err != nil {
+			if err == io.EOF {
+				err = errors.New("no 'svg' element")
+			}
+			return
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if start.Name.Local != "svg" {
+			err = errors.New("root element is not 'svg'")
+			return
+		}
+		var widthAttr, heightAttr, viewBoxAttr string
+		for _, attr := range start.Attr {
+			switch attr.Name.Local {
+			case "width":
+				widthAttr = attr.Value
+			case "height":
+				heightAttr = attr.Value
+			case "viewBox":
+				viewBoxAttr = attr.Value
+			}
+		}
+		width, hasWidth := parseSVGLength(widthAttr)
+		height, hasHeight := parseSVGLength(heightAttr)
+		var viewBox Dims
+		hasViewBox := false
+		if fields := strings.FieldsFunc(viewBoxAttr, func(r rune) bool { return r == ' ' || r == ',' || r == '\t' || r == '\n' }); len(fields) == 4 {
+			w, errW := strconv.ParseFloat(fields[2], 64)
+			h, errH := strconv.ParseFloat(fields[3], 64)
+			if errW == nil && errH == nil && w > 0 && h > 0 {
+				viewBox, hasViewBox = NewDims(w, h), true
+			}
+		}
+		switch {
+		case hasWidth && hasHeight:
+			dims = NewDims(width, height)
+		case hasWidth && hasViewBox:
+			dims = NewDims(width, width*viewBox.Y()/viewBox.X())
+		case hasHeight && hasViewBox:
+			dims = NewDims(height*viewBox.X()/viewBox.Y(), height)
+		case hasViewBox:
+			dims = viewBox
+		default:
+			err = errors.New("SVG image has no intrinsic size (no absolute 'width' and 'height', and no 'viewBox')")
+		}
+		return
+	}
+}
+
+// Determines whether a file is an SVG image, by its extension or its first bytes.
+func isSVGFile(fileName string, header []byte) bool {
+	return strings.EqualFold(filepath.Ext(fileName), ".svg") || detectImageFormat(header) == "SVG"
+}
+
+// Reads an SVG image for inclusion in an ImageLayout.
+func loadVectorImage(id ImageIdentifier, fileName string, reader io.Reader) (ImageInfo, error) {
+	dims, err := ReadSVGDimensions(reader)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fileName, err)
+	}
+	return ImageInfo_vector{id, fileName, dims}, nil
+}
@@ -0,0 +1,21 @@
+package CollageCreator
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVectorImageInManifest(t *testing.T) {
+	parameters := newTestParameters()
+	parameters.SetOther(RasterRenderer_Depth, 0)
+	vector := ImageInfo_vector{id: 0, fileName: "drawing.svg", dims: NewDims(300, 200)}
+	annotated := ImageInfo_annotated{ImageInfo: vector, annotations: ImageAnnotations{Weight: 1, Cropping: EmptyGeometry(), Scaling: EmptyGeometry()}}
+	if !IsVectorImage(annotated) {
+		t.Fatal("an SVG listed in a manifest is not recognized as a vector image")
+	}
+	imageLayout := createImageLayout(parameters, []ImageInfo{annotated})
+	imageLayout.SetCanvasSize(NewDims(300, 200))
+	if _, err := createCollageImage(imageLayout); err == nil || !strings.Contains(err.Error(), "not rasterizable") {
+		t.Errorf("rendering an SVG listed in a manifest gave error %v", err)
+	}
+}
@@ -0,0 +1,48 @@
+package CollageCreator
+
+import (
+	"image"
+	"testing"
+)
+
+// Creates parameters for a test, with no padding and progress reported as usual.
+func newTestParameters() *Parameters {
+	parameters := Parameters_init()
+	parameters.SetProgressMonitor(ProgressMonitor_Init())
+	parameters.SetPadding(MustParseGeometry("0x0"))
+	return &parameters
+}
+
+// Creates a layout of blank images of the given sizes.
+func newTestLayout(parameters *Parameters, sizes ...Dims) ImageLayout {
+	images := make([]image.Image, len(sizes))
+	for i, size := range sizes {
+		images[i] = image.NewGray(image.Rect(0, 0, int(size.X()), int(size.Y())))
+	}
+	return CreateImageLayoutFromImages(parameters, images)
+}
+
+// Fails the test if 'got' and 'want' differ by more than 'tolerance'.
+func assertNear(t *testing.T, what string, got, want, tolerance float64) {
+	t.Helper()
+	if got < want-tolerance || got > want+tolerance {
+		t.Errorf("%s = %v, want %v", what, got, want)
+	}
+}
+
+// A progress monitor that records the messages reported to it.
+type recordingProgressMonitor struct {
+	ProgressMonitor_impl
+	messages *[]string
+}
+
+func (rpm recordingProgressMonitor) ReportMessage(msg string) {
+	*rpm.messages = append(*rpm.messages, msg)
+}
+
+// Creates parameters for a test whose messages are recorded in 'messages'.
+func newRecordingTestParameters(messages *[]string) *Parameters {
+	parameters := newTestParameters()
+	parameters.SetProgressMonitor(recordingProgressMonitor{ProgressMonitor_Init(), messages})
+	return parameters
+}